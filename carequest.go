@@ -0,0 +1,110 @@
+package liftca
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"time"
+)
+
+// DefaultMaxPathLen is the path length constraint of root CAs created
+// without one.
+const DefaultMaxPathLen = 16
+
+// CASettings holds the per-CA defaults applied when it issues certificates.
+type CASettings struct {
+	// DefaultTTL is the validity period of issued certificates that do not
+	// request one; zero means DefaultCertTTL.
+	DefaultTTL time.Duration
+	// CRLTTL is how long the CRLs of the CA are good for; zero means
+	// DefaultCRLTTL.
+	CRLTTL time.Duration
+	// DeltaCRLTTL, when set, has the CA publish delta CRLs good for that
+	// long, listing the changes since its last complete CRL.  Complete CRLs
+	// are then only issued as they get old, rather than on every change.
+	DeltaCRLTTL time.Duration
+	// NameConstraints are enforced by liftCA on top of those of the CA's
+	// certificate.  They are meant for imported CAs, whose certificate cannot
+	// be changed.
+	NameConstraints NameConstraints
+	// SignatureAlgorithm and KeyIDMethod apply to every certificate and
+	// CRL the CA signs.
+	SignatureAlgorithm SignatureAlgorithm
+	KeyIDMethod        KeyIDMethod
+	// SerialPolicy picks the serial numbers of the certificates the CA
+	// issues.
+	SerialPolicy SerialPolicy
+	// DelegatedOCSP has the CA issue a short-lived certificate to sign its
+	// OCSP responses, so that its own key is used less often.
+	DelegatedOCSP bool
+}
+
+// validate checks that the settings can be used by a CA whose key is pub.
+func (s *CASettings) validate(pub crypto.PublicKey) error {
+	if _, err := x509SignatureAlgorithm(pub, s.SignatureAlgorithm); err != nil {
+		return err
+	}
+	if _, err := subjectKeyID(pub, s.KeyIDMethod); err != nil {
+		return err
+	}
+	if err := s.SerialPolicy.validate(); err != nil {
+		return err
+	}
+	if s.CRLTTL < 0 {
+		return fmt.Errorf("CRL validity must be positive")
+	}
+	crlTTL := s.CRLTTL
+	if crlTTL == 0 {
+		crlTTL = DefaultCRLTTL
+	}
+	if s.DeltaCRLTTL < 0 {
+		return fmt.Errorf("delta CRL validity must be positive")
+	}
+	if s.DeltaCRLTTL >= crlTTL {
+		return fmt.Errorf("delta CRL validity (%v) must be shorter than the CRL validity (%v)", s.DeltaCRLTTL, crlTTL)
+	}
+	return s.NameConstraints.validate()
+}
+
+// CARequest describes a CA to be created.
+type CARequest struct {
+	Subject  pkix.Name
+	KeyType  KeyType
+	Validity Validity
+	// MaxPathLen is the maximum number of intermediate CAs that may follow
+	// this one in a chain.  If nil, root CAs get DefaultMaxPathLen and
+	// intermediate CAs one less than their issuer's.
+	MaxPathLen *int
+	// NameConstraints are written into the CA's certificate.
+	NameConstraints NameConstraints
+	Policies        []Policy
+	Extensions      []Extension
+	Settings        CASettings
+}
+
+// maxPathLen returns the path length constraint of the CA, which must fit
+// under that of issuer, if any.
+func (r *CARequest) maxPathLen(issuer *x509.Certificate) (int, error) {
+	limit := -1
+	if issuer != nil && (issuer.MaxPathLen > 0 || issuer.MaxPathLenZero) {
+		limit = issuer.MaxPathLen - 1
+		if limit < 0 {
+			return 0, fmt.Errorf("issuer's path length constraint does not allow intermediate CAs")
+		}
+	}
+	if r.MaxPathLen == nil {
+		if limit >= 0 {
+			return limit, nil
+		}
+		return DefaultMaxPathLen, nil
+	}
+	n := *r.MaxPathLen
+	if n < 0 {
+		return 0, fmt.Errorf("maxPathLen must not be negative")
+	}
+	if limit >= 0 && n > limit {
+		return 0, fmt.Errorf("maxPathLen %v exceeds the %v allowed by the issuer", n, limit)
+	}
+	return n, nil
+}
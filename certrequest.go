@@ -0,0 +1,152 @@
+package liftca
+
+import (
+	"crypto/x509/pkix"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"strings"
+)
+
+// CertRequest describes a certificate to be issued by a CA.
+type CertRequest struct {
+	// Subject.CommonName defaults to the first usable name; see subject.
+	Subject        pkix.Name
+	KeyType        KeyType
+	Profile        Profile
+	DNSNames       []string
+	IPAddresses    []net.IP
+	EmailAddresses []string
+	URIs           []*url.URL
+	Validity       Validity
+	Policies       []Policy
+	Extensions     []Extension
+}
+
+func (r *CertRequest) validate() error {
+	if err := validateSubject(r.Subject); err != nil {
+		return err
+	}
+	if len(r.DNSNames) == 0 && len(r.IPAddresses) == 0 && len(r.EmailAddresses) == 0 && len(r.URIs) == 0 {
+		return fmt.Errorf("at least one DNS name, IP address, email address or URI is required")
+	}
+	for _, name := range r.DNSNames {
+		if !validDNSName(name) {
+			return fmt.Errorf("invalid DNS name '%v'", name)
+		}
+	}
+	for _, email := range r.EmailAddresses {
+		if !validEmailAddress(email) {
+			return fmt.Errorf("invalid email address '%v'", email)
+		}
+	}
+	for _, uri := range r.URIs {
+		if err := validateURI(uri); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subject returns the requested subject, with a common name filled in if it
+// has none.
+func (r *CertRequest) subject() pkix.Name {
+	subject := r.Subject
+	if subject.CommonName == "" {
+		subject.CommonName = r.commonName()
+	}
+	return subject
+}
+
+// commonName returns the name to use as the subject common name: the first
+// DNS name, or else the first IP address, or else the first email address.
+// URIs are never used, since workload identities such as SPIFFE IDs do not
+// belong in the subject.
+func (r *CertRequest) commonName() string {
+	if len(r.DNSNames) > 0 {
+		return r.DNSNames[0]
+	}
+	if len(r.IPAddresses) > 0 {
+		return r.IPAddresses[0].String()
+	}
+	if len(r.EmailAddresses) > 0 {
+		return r.EmailAddresses[0]
+	}
+	return ""
+}
+
+// validateSubject checks the attributes of a subject that have a fixed form.
+func validateSubject(subject pkix.Name) error {
+	for _, c := range subject.Country {
+		if len(c) != 2 || strings.ToUpper(c) != c || strings.ToLower(c) == c {
+			return fmt.Errorf("invalid country '%v': must be a two-letter ISO 3166 code such as 'CA'", c)
+		}
+	}
+	return nil
+}
+
+// validDNSName reports whether name is a valid host name, optionally with a
+// wildcard as its complete left-most label (e.g. '*.example.com').
+func validDNSName(name string) bool {
+	name = strings.TrimPrefix(name, "*.")
+	if len(name) == 0 || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return false
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			switch {
+			case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_':
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validEmailAddress reports whether email is a bare rfc822Name mailbox, such
+// as 'user@example.com'.
+func validEmailAddress(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		return false
+	}
+	at := strings.LastIndex(email, "@")
+	return at > 0 && validDNSName(email[at+1:]) && !strings.HasPrefix(email[at+1:], "*.")
+}
+
+// validateURI checks that uri can be used as a URI SAN: it must be absolute,
+// and SPIFFE IDs must follow the SPIFFE ID specification.
+func validateURI(uri *url.URL) error {
+	if !uri.IsAbs() || (uri.Host == "" && uri.Opaque == "") {
+		return fmt.Errorf("invalid URI '%v': must be absolute", uri)
+	}
+	if uri.Scheme != "spiffe" {
+		return nil
+	}
+	if uri.User != nil || uri.Port() != "" || uri.RawQuery != "" || uri.Fragment != "" {
+		return fmt.Errorf("invalid SPIFFE ID '%v': must not have a user, port, query or fragment", uri)
+	}
+	for _, c := range uri.Host {
+		switch {
+		case 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '.', c == '-', c == '_':
+		default:
+			return fmt.Errorf("invalid SPIFFE ID '%v': trust domain must be lowercase letters, digits, '.', '-' or '_'", uri)
+		}
+	}
+	if uri.Path != "" {
+		for _, segment := range strings.Split(strings.TrimPrefix(uri.Path, "/"), "/") {
+			if segment == "" || segment == "." || segment == ".." {
+				return fmt.Errorf("invalid SPIFFE ID '%v': empty, '.' or '..' path segment", uri)
+			}
+		}
+	}
+	return nil
+}
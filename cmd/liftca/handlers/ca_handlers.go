@@ -1,11 +1,16 @@
 package handlers
 
 import (
+	"bytes"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"net/url"
 	"strconv"
 
 	"github.com/jeanfric/liftca"
 	"github.com/jeanfric/liftca/ht"
+	"golang.org/x/crypto/ocsp"
 )
 
 func GetCACertificateCER(store *liftca.Store, r *ht.Request) *ht.Answer {
@@ -16,13 +21,39 @@ func GetCACertificateCER(store *liftca.Store, r *ht.Request) *ht.Answer {
 	return ht.Read("application/pkix-cert", ca.DERCertificate())
 }
 
+func GetCAChainPEM(store *liftca.Store, r *ht.Request) *ht.Answer {
+	ca, answer := ObtainCA(store, r)
+	if answer != nil {
+		return answer
+	}
+	chain, answer := ObtainChain(store, r, ca.ID)
+	if answer != nil {
+		return answer
+	}
+	return ht.Read("application/x-pem-file", liftca.PEMChain(chain))
+}
+
+func GetCAChainPEMTXT(store *liftca.Store, r *ht.Request) *ht.Answer {
+	ca, answer := ObtainCA(store, r)
+	if answer != nil {
+		return answer
+	}
+	chain, answer := ObtainChain(store, r, ca.ID)
+	if answer != nil {
+		return answer
+	}
+	return ht.Read("text/plain", liftca.PEMChain(chain))
+}
+
 func GetCACRLCER(store *liftca.Store, r *ht.Request) *ht.Answer {
 	ca, answer := ObtainCA(store, r)
 	if answer != nil {
 		return answer
 	}
-	revoked := store.GetRevokedChildren(ca.SerialNumber())
-	crl, err := ca.DERCRL(revoked)
+	if !ca.HasPrivateKey() {
+		return ht.NotFound()
+	}
+	crl, err := store.DERCRL(ca.ID)
 	if err != nil {
 		return ht.Failure(err)
 	}
@@ -34,6 +65,9 @@ func GetCAPrivateKeyCER(store *liftca.Store, r *ht.Request) *ht.Answer {
 	if answer != nil {
 		return answer
 	}
+	if !ca.HasPrivateKey() {
+		return ht.NotFound()
+	}
 	return ht.Read("application/pkix-cert", ca.DERPrivateKey())
 }
 
@@ -58,6 +92,9 @@ func GetCAPrivateKeyPEM(store *liftca.Store, r *ht.Request) *ht.Answer {
 	if answer != nil {
 		return answer
 	}
+	if !ca.HasPrivateKey() {
+		return ht.NotFound()
+	}
 	return ht.Read("application/x-pem-file", ca.PEMPrivateKey())
 }
 
@@ -66,6 +103,9 @@ func GetCAPrivateKeyPEMTXT(store *liftca.Store, r *ht.Request) *ht.Answer {
 	if answer != nil {
 		return answer
 	}
+	if !ca.HasPrivateKey() {
+		return ht.NotFound()
+	}
 	return ht.Read("text/plain", ca.PEMPrivateKey())
 }
 
@@ -74,8 +114,10 @@ func GetCACRLPEM(store *liftca.Store, r *ht.Request) *ht.Answer {
 	if answer != nil {
 		return answer
 	}
-	revoked := store.GetRevokedChildren(ca.SerialNumber())
-	crl, err := ca.PEMCRL(revoked)
+	if !ca.HasPrivateKey() {
+		return ht.NotFound()
+	}
+	crl, err := store.PEMCRL(ca.ID)
 	if err != nil {
 		return ht.Failure(err)
 	}
@@ -87,8 +129,55 @@ func GetCACRLPEMTXT(store *liftca.Store, r *ht.Request) *ht.Answer {
 	if answer != nil {
 		return answer
 	}
-	revoked := store.GetRevokedChildren(ca.SerialNumber())
-	crl, err := ca.PEMCRL(revoked)
+	if !ca.HasPrivateKey() {
+		return ht.NotFound()
+	}
+	crl, err := store.PEMCRL(ca.ID)
+	if err != nil {
+		return ht.Failure(err)
+	}
+	return ht.Read("text/plain", crl)
+}
+
+func GetCADeltaCRLCER(store *liftca.Store, r *ht.Request) *ht.Answer {
+	ca, answer := ObtainCA(store, r)
+	if answer != nil {
+		return answer
+	}
+	if !ca.HasPrivateKey() || ca.DeltaCRLTTL() == 0 {
+		return ht.NotFound()
+	}
+	crl, err := store.DERDeltaCRL(ca.ID)
+	if err != nil {
+		return ht.Failure(err)
+	}
+	return ht.Read("application/pkix-crl", crl)
+}
+
+func GetCADeltaCRLPEM(store *liftca.Store, r *ht.Request) *ht.Answer {
+	ca, answer := ObtainCA(store, r)
+	if answer != nil {
+		return answer
+	}
+	if !ca.HasPrivateKey() || ca.DeltaCRLTTL() == 0 {
+		return ht.NotFound()
+	}
+	crl, err := store.PEMDeltaCRL(ca.ID)
+	if err != nil {
+		return ht.Failure(err)
+	}
+	return ht.Read("application/x-pem-file", crl)
+}
+
+func GetCADeltaCRLPEMTXT(store *liftca.Store, r *ht.Request) *ht.Answer {
+	ca, answer := ObtainCA(store, r)
+	if answer != nil {
+		return answer
+	}
+	if !ca.HasPrivateKey() || ca.DeltaCRLTTL() == 0 {
+		return ht.NotFound()
+	}
+	crl, err := store.PEMDeltaCRL(ca.ID)
 	if err != nil {
 		return ht.Failure(err)
 	}
@@ -100,34 +189,70 @@ func GetCRL(store *liftca.Store, r *ht.Request) *ht.Answer {
 	if answer != nil {
 		return answer
 	}
-	revoked := store.GetRevokedChildren(ca.SerialNumber())
-	output := make([]string, len(revoked))
+	revoked := store.GetRevokedChildren(ca.ID)
+	ids := make([]string, len(revoked))
 	for i, e := range revoked {
-		output[i] = strconv.FormatInt(e, 10)
+		ids[i] = strconv.FormatInt(e, 10)
+	}
+	serials := store.GetRevokedSerialNumbers(ca.ID)
+	serialNumbers := make([]string, len(serials))
+	for i, e := range serials {
+		serialNumbers[i] = e.String()
+	}
+	revocations := make([]JSONRevocation, 0, len(revoked))
+	for _, id := range revoked {
+		cert, _ := store.Get(id)
+		rev, _ := store.GetRevocation(id)
+		revocations = append(revocations, JSONRevocationFromRevocation(cert, rev))
+	}
+	crlNumber := ""
+	if number, found := store.GetCRLNumber(ca.ID); found {
+		crlNumber = number.String()
+	}
+	deltaCRLNumber := ""
+	if number, found := store.GetDeltaCRLNumber(ca.ID); found {
+		deltaCRLNumber = number.String()
 	}
 
 	return ht.JSONDocument(&JSONCRLResponse{
-		Self:          CACRLURL(ca.SerialNumber()),
-		SerialNumbers: output,
+		Self:           CACRLURL(ca.ID),
+		IDs:            ids,
+		SerialNumbers:  serialNumbers,
+		Revocations:    revocations,
+		CRLNumber:      crlNumber,
+		CRLTTL:         ca.CRLTTL().String(),
+		DeltaCRLNumber: deltaCRLNumber,
 	})
 }
 
 func PostCRL(store *liftca.Store, r *ht.Request) *ht.Answer {
 	req := &JSONCRLRequest{}
 	r.BodyAsJSON(req)
-	certID, err := strconv.ParseInt(req.SerialNumber, 10, 64)
+	if req.ID == "" {
+		req.ID = req.SerialNumber
+	}
+	certID, err := strconv.ParseInt(req.ID, 10, 64)
 	if err != nil {
 		return ht.Failure(err)
 	}
-	ca, answer := ObtainCA(store, r)
+	ca, answer := ObtainIssuingCA(store, r)
 	if answer != nil {
 		return answer
 	}
-	if p, _ := store.GetParent(certID); p != ca.SerialNumber() {
-		return ht.Failure(fmt.Errorf("certificate %v does not belong to CA %v", certID, ca.SerialNumber()))
+	if p, _ := store.GetParent(certID); p != ca.ID {
+		return ht.Failure(fmt.Errorf("certificate %v does not belong to CA %v", certID, ca.ID))
+	}
+	rev, err := req.Revocation()
+	if err != nil {
+		return ht.Failure(err)
+	}
+	if rev.RevokedBy == "" {
+		rev.RevokedBy = r.RemoteAddr()
 	}
-	store.SetRevoked(certID, true)
-	return ht.RedirectTo(CACRLURL(ca.SerialNumber()))
+	if err := store.Revoke(certID, rev); err != nil {
+		return ht.Failure(err)
+	}
+	return ht.RedirectTo(CACRLURL(ca.ID))
 }
 
 func DeleteCRL(store *liftca.Store, r *ht.Request) *ht.Answer {
@@ -135,16 +260,78 @@ func DeleteCRL(store *liftca.Store, r *ht.Request) *ht.Answer {
 	if answer != nil {
 		return answer
 	}
-	store.SetRevoked(cert.SerialNumber(), false)
+	store.Unrevoke(cert.ID)
 	return ht.NoContent()
 }
 
+// GetOCSP answers an OCSP request sent with GET, base64 encoded in the last
+// path segment (RFC 6960, appendix A.1).
+func GetOCSP(store *liftca.Store, r *ht.Request) *ht.Answer {
+	caID, err := r.VarInt64("ca_id")
+	if err != nil {
+		return ht.Failure(err)
+	}
+	encoded, err := url.PathUnescape(r.Var("request"))
+	if err != nil {
+		return ht.Read("application/ocsp-response", bytes.NewReader(ocsp.MalformedRequestErrorResponse))
+	}
+	req, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return ht.Read("application/ocsp-response", bytes.NewReader(ocsp.MalformedRequestErrorResponse))
+	}
+	return ocspAnswer(store, caID, req)
+}
+
+// PostOCSP answers an OCSP request sent as the body of a POST.
+func PostOCSP(store *liftca.Store, r *ht.Request) *ht.Answer {
+	caID, err := r.VarInt64("ca_id")
+	if err != nil {
+		return ht.Failure(err)
+	}
+	req, err := r.Body()
+	if err != nil {
+		return ht.Failure(err)
+	}
+	return ocspAnswer(store, caID, req)
+}
+
+func ocspAnswer(store *liftca.Store, caID int64, req []byte) *ht.Answer {
+	resp, err := store.OCSPResponse(caID, req)
+	if err != nil {
+		return ht.Failure(err)
+	}
+	return ht.Read("application/ocsp-response", bytes.NewReader(resp))
+}
+
+// caResponse describes ca, including a link to its issuer if it has one.
+func caResponse(store *liftca.Store, ca *liftca.Parcel) *JSONCAResponse {
+	response := JSONCAResponseFromParcel(ca)
+	if parent, found := store.GetParent(ca.ID); found {
+		response.Parent = CAUrl(parent)
+		response.ParentID = strconv.FormatInt(parent, 10)
+	}
+	if predecessor, found := store.GetPredecessor(ca.ID); found {
+		response.PredecessorID = strconv.FormatInt(predecessor, 10)
+	}
+	for _, s := range store.GetSuccessors(ca.ID) {
+		response.SuccessorIDs = append(response.SuccessorIDs, strconv.FormatInt(s, 10))
+	}
+	if crossed, found := store.GetCrossCertified(ca.ID); found {
+		response.CrossCertifiedID = strconv.FormatInt(crossed, 10)
+	}
+	for _, s := range store.GetCrossCertificates(ca.ID) {
+		response.CrossCertificateIDs = append(response.CrossCertificateIDs, strconv.FormatInt(s, 10))
+	}
+	response.ChainRootIDs = chainRootIDs(store, ca.ID)
+	return response
+}
+
 func GetCAs(store *liftca.Store, r *ht.Request) *ht.Answer {
 	response := make([]JSONCAResponse, 0)
 	for _, s := range store.GetCAs() {
 		auth, _ := store.Get(s)
 		if auth.Visible {
-			response = append(response, *JSONCAResponseFromParcel(auth))
+			response = append(response, *caResponse(store, auth))
 		}
 	}
 	return ht.JSONDocument(response)
@@ -155,8 +342,89 @@ func GetCA(store *liftca.Store, r *ht.Request) *ht.Answer {
 	if answer != nil {
 		return answer
 	}
-	auth, _ := store.Get(ca.SerialNumber())
-	return ht.JSONDocument(*JSONCAResponseFromParcel(auth))
+	return ht.JSONDocument(*caResponse(store, ca))
+}
+
+func GetSubCAs(store *liftca.Store, r *ht.Request) *ht.Answer {
+	ca, answer := ObtainCA(store, r)
+	if answer != nil {
+		return answer
+	}
+	children, _ := store.GetChildren(ca.ID)
+	response := make([]JSONCAResponse, 0)
+	for _, s := range children {
+		sub, _ := store.Get(s)
+		if sub.Certificate.IsCA {
+			response = append(response, *caResponse(store, sub))
+		}
+	}
+	return ht.JSONDocument(response)
+}
+
+func PostSubCA(store *liftca.Store, r *ht.Request) *ht.Answer {
+	ca, answer := ObtainIssuingCA(store, r)
+	if answer != nil {
+		return answer
+	}
+	caReq := &JSONCARequest{}
+	err := r.BodyAsJSON(caReq)
+	if err != nil {
+		return ht.Failure(err)
+	}
+	req, err := caReq.CARequest()
+	if err != nil {
+		return ht.Failure(err)
+	}
+	id, err := store.AddSubCA(caReq.Visible, ca.ID, req)
+	if err != nil {
+		return ht.Failure(err)
+	}
+	return ht.RedirectTo(CAUrl(id))
+}
+
+// PostCrossSign has a CA cross-sign the CA given in the request.
+func PostCrossSign(store *liftca.Store, r *ht.Request) *ht.Answer {
+	ca, answer := ObtainIssuingCA(store, r)
+	if answer != nil {
+		return answer
+	}
+	crossReq := &JSONCrossSignRequest{}
+	err := r.BodyAsJSON(crossReq)
+	if err != nil {
+		return ht.Failure(err)
+	}
+	subject, err := strconv.ParseInt(crossReq.CAID, 10, 64)
+	if err != nil {
+		return ht.Failure(err)
+	}
+	id, err := store.CrossSign(ca.ID, subject)
+	if err != nil {
+		return ht.Failure(err)
+	}
+	return ht.RedirectTo(CAUrl(id))
+}
+
+// PostRollover creates the next generation of a root CA, along with the
+// cross certificates between the two generations.
+func PostRollover(store *liftca.Store, r *ht.Request) *ht.Answer {
+	ca, answer := ObtainIssuingCA(store, r)
+	if answer != nil {
+		return answer
+	}
+	rolloverReq := &JSONRolloverRequest{}
+	err := r.BodyAsJSON(rolloverReq)
+	if err != nil {
+		return ht.Failure(err)
+	}
+	req, err := rolloverReq.RolloverRequest()
+	if err != nil {
+		return ht.Failure(err)
+	}
+	rollover, err := store.RolloverCA(ca.ID, req)
+	if err != nil {
+		return ht.Failure(err)
+	}
+	return ht.RedirectTo(CAUrl(rollover.NewCA))
 }
 
 func PostCA(store *liftca.Store, r *ht.Request) *ht.Answer {
@@ -166,10 +434,32 @@ func PostCA(store *liftca.Store, r *ht.Request) *ht.Answer {
 		return ht.Failure(err)
 	}
 	var id int64
-	if caReq.PEMCertificate != "" || caReq.PEMKey != "" || caReq.PEMKeyPassword != "" {
-		id, err = store.AddExistingCA(caReq.Visible, []byte(caReq.PEMCertificate), []byte(caReq.PEMKey), []byte(caReq.PEMKeyPassword))
+	if caReq.PEMCertificate != "" || caReq.PEMKey != "" || caReq.PEMKeyPassword != "" || len(caReq.PKCS12) != 0 {
+		var settings liftca.CASettings
+		settings, err = caReq.CASettings()
+		if err != nil {
+			return ht.Failure(err)
+		}
+		settings.NameConstraints, err = caReq.NameConstraints.NameConstraints()
+		if err != nil {
+			return ht.Failure(err)
+		}
+		if len(caReq.PKCS12) != 0 {
+			id, err = store.AddExistingCAFromPKCS12(caReq.Visible, caReq.PKCS12, []byte(caReq.PEMKeyPassword), settings)
+		} else {
+			id, err = store.AddExistingCA(caReq.Visible, []byte(caReq.PEMCertificate), []byte(caReq.PEMKey), []byte(caReq.PEMKeyPassword), []byte(caReq.PEMChain), settings)
+		}
+		var existing *liftca.ExistingCAError
+		if errors.As(err, &existing) {
+			return ht.Conflict(err)
+		}
 	} else {
-		id, err = store.AddCA(caReq.Visible, caReq.Name)
+		var req *liftca.CARequest
+		req, err = caReq.CARequest()
+		if err != nil {
+			return ht.Failure(err)
+		}
+		id, err = store.AddCA(caReq.Visible, req)
 	}
 
 	if err != nil {
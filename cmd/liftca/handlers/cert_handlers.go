@@ -1,6 +1,10 @@
 package handlers
 
 import (
+	"bytes"
+	"fmt"
+	"strconv"
+
 	"github.com/jeanfric/liftca"
 	"github.com/jeanfric/liftca/ht"
 )
@@ -21,11 +25,38 @@ func GetCertificatePEMTXT(store *liftca.Store, r *ht.Request) *ht.Answer {
 	return ht.Read("text/plain", cert.PEMCertificate())
 }
 
+func GetCertificateChainPEM(store *liftca.Store, r *ht.Request) *ht.Answer {
+	_, cert, answer := ObtainCAAndCert(store, r)
+	if answer != nil {
+		return answer
+	}
+	chain, answer := ObtainChain(store, r, cert.ID)
+	if answer != nil {
+		return answer
+	}
+	return ht.Read("application/x-pem-file", liftca.PEMChain(chain))
+}
+
+func GetCertificateChainPEMTXT(store *liftca.Store, r *ht.Request) *ht.Answer {
+	_, cert, answer := ObtainCAAndCert(store, r)
+	if answer != nil {
+		return answer
+	}
+	chain, answer := ObtainChain(store, r, cert.ID)
+	if answer != nil {
+		return answer
+	}
+	return ht.Read("text/plain", liftca.PEMChain(chain))
+}
+
 func GetCertificatePrivateKeyPEM(store *liftca.Store, r *ht.Request) *ht.Answer {
 	_, cert, answer := ObtainCAAndCert(store, r)
 	if answer != nil {
 		return answer
 	}
+	if !cert.HasPrivateKey() {
+		return ht.NotFound()
+	}
 	return ht.Read("application/x-pem-file", cert.PEMPrivateKey())
 }
 
@@ -34,6 +65,9 @@ func GetCertificatePrivateKeyPEMTXT(store *liftca.Store, r *ht.Request) *ht.Answ
 	if answer != nil {
 		return answer
 	}
+	if !cert.HasPrivateKey() {
+		return ht.NotFound()
+	}
 	return ht.Read("text/plain", cert.PEMPrivateKey())
 }
 
@@ -42,6 +76,9 @@ func GetCertificatePrivateKeyCER(store *liftca.Store, r *ht.Request) *ht.Answer
 	if answer != nil {
 		return answer
 	}
+	if !cert.HasPrivateKey() {
+		return ht.NotFound()
+	}
 	return ht.Read("application/pkix-cert", cert.DERPrivateKey())
 }
 
@@ -53,22 +90,38 @@ func GetCertificateCER(store *liftca.Store, r *ht.Request) *ht.Answer {
 	return ht.Read("application/pkix-cert", cert.DERCertificate())
 }
 
+// GetCertificateOCSPDER returns an OCSP response about the certificate, for
+// servers to staple, such as with the ssl_stapling_file directive of nginx.
+func GetCertificateOCSPDER(store *liftca.Store, r *ht.Request) *ht.Answer {
+	_, cert, answer := ObtainCAAndCert(store, r)
+	if answer != nil {
+		return answer
+	}
+	resp, err := store.OCSPStaple(cert.ID)
+	if err != nil {
+		return ht.Failure(err)
+	}
+	return ht.Read("application/ocsp-response", bytes.NewReader(resp))
+}
+
 func GetCerts(store *liftca.Store, r *ht.Request) *ht.Answer {
 	ca, answer := ObtainCA(store, r)
 	if answer != nil {
 		return answer
 	}
-	children, _ := store.GetChildren(ca.SerialNumber())
+	children, _ := store.GetChildren(ca.ID)
 	response := make([]JSONCertResponse, 0)
 	for _, s := range children {
 		cert, _ := store.Get(s)
-		response = append(response, *JSONCertResponseFromParcel(ca.SerialNumber(), cert))
+		if !cert.Certificate.IsCA {
+			response = append(response, *certResponse(store, ca.ID, cert))
+		}
 	}
 	return ht.JSONDocument(response)
 }
 
 func PostCert(store *liftca.Store, r *ht.Request) *ht.Answer {
-	ca, answer := ObtainCA(store, r)
+	ca, answer := ObtainIssuingCA(store, r)
 	if answer != nil {
 		return answer
 	}
@@ -77,11 +130,72 @@ func PostCert(store *liftca.Store, r *ht.Request) *ht.Answer {
 	if err != nil {
 		return ht.Failure(err)
 	}
-	id, err := store.Add(true, ca.SerialNumber(), certReq.Host)
+	req, err := certReq.CertRequest()
+	if err != nil {
+		return ht.Failure(err)
+	}
+	id, err := store.Add(true, ca.ID, req)
+	if err != nil {
+		return ht.Failure(err)
+	}
+	return ht.RedirectTo(CertUrl(ca.ID, id))
+}
+
+// PostCerts imports existing leaf certificates, each under the CA that
+// issued it, and reports the outcome for each of them.
+func PostCerts(store *liftca.Store, r *ht.Request) *ht.Answer {
+	importReq := &JSONCertImportRequest{}
+	err := r.BodyAsJSON(importReq)
+	if err != nil {
+		return ht.Failure(err)
+	}
+	caIDs := make([]int64, len(importReq.CAIDs))
+	for i, id := range importReq.CAIDs {
+		caIDs[i], err = strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return ht.Failure(fmt.Errorf("invalid CA id '%v'", id))
+		}
+	}
+	imported, err := store.AddExistingCerts(true, []byte(importReq.PEMCertificates), []byte(importReq.PEMKeys), []byte(importReq.PEMKeyPassword), caIDs)
+	if err != nil {
+		return ht.Failure(err)
+	}
+	response := make([]JSONCertImportResponse, len(imported))
+	for i, c := range imported {
+		response[i] = JSONCertImportResponseFromImportedCert(c)
+	}
+	return ht.JSONDocument(response)
+}
+
+// PostCSR signs a certificate signing request.  The body is either the PEM
+// or DER encoded request itself, or a JSONCSRRequest.
+func PostCSR(store *liftca.Store, r *ht.Request) *ht.Answer {
+	ca, answer := ObtainIssuingCA(store, r)
+	if answer != nil {
+		return answer
+	}
+	var csr []byte
+	req := &liftca.CertRequest{}
+	var err error
+	if r.ContentType() == "application/json" {
+		csrReq := &JSONCSRRequest{}
+		err = r.BodyAsJSON(csrReq)
+		if err != nil {
+			return ht.Failure(err)
+		}
+		csr = []byte(csrReq.CSR)
+		req, err = csrReq.CertRequest()
+	} else {
+		csr, err = r.Body()
+	}
+	if err != nil {
+		return ht.Failure(err)
+	}
+	id, err := store.AddFromCSR(true, ca.ID, csr, req)
 	if err != nil {
 		return ht.Failure(err)
 	}
-	return ht.RedirectTo(CertUrl(ca.SerialNumber(), id))
+	return ht.RedirectTo(CertUrl(ca.ID, id))
 }
 
 func GetCert(store *liftca.Store, r *ht.Request) *ht.Answer {
@@ -89,5 +203,45 @@ func GetCert(store *liftca.Store, r *ht.Request) *ht.Answer {
 	if answer != nil {
 		return answer
 	}
-	return ht.JSONDocument(JSONCertResponseFromParcel(ca.SerialNumber(), cert))
+	return ht.JSONDocument(certResponse(store, ca.ID, cert))
+}
+
+// PostRenew issues the successor of a certificate.
+func PostRenew(store *liftca.Store, r *ht.Request) *ht.Answer {
+	ca, cert, answer := ObtainCAAndCert(store, r)
+	if answer != nil {
+		return answer
+	}
+	if !ca.HasPrivateKey() {
+		return ht.NotFound()
+	}
+	renewReq := &JSONRenewRequest{}
+	err := r.BodyAsJSON(renewReq)
+	if err != nil {
+		return ht.Failure(err)
+	}
+	req, err := renewReq.RenewRequest()
+	if err != nil {
+		return ht.Failure(err)
+	}
+	id, err := store.Renew(cert.ID, req)
+	if err != nil {
+		return ht.Failure(err)
+	}
+	return ht.RedirectTo(CertUrl(ca.ID, id))
+}
+
+// certResponse describes cert, including links to the certificate it
+// renewed and those that renewed it.
+func certResponse(store *liftca.Store, caId int64, cert *liftca.Parcel) *JSONCertResponse {
+	response := JSONCertResponseFromParcel(caId, cert)
+	if predecessor, found := store.GetPredecessor(cert.ID); found {
+		response.Predecessor = CertUrl(caId, predecessor)
+		response.PredecessorID = strconv.FormatInt(predecessor, 10)
+	}
+	for _, s := range store.GetSuccessors(cert.ID) {
+		response.SuccessorIDs = append(response.SuccessorIDs, strconv.FormatInt(s, 10))
+	}
+	response.ChainRootIDs = chainRootIDs(store, cert.ID)
+	return response
 }
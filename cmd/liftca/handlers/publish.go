@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jeanfric/liftca"
+)
+
+// Publish writes the certificates, chains and current CRLs of every CA into
+// dir, at the paths liftCA serves them from, so that any web server can
+// serve them in its stead.  Files whose content did not change are left
+// alone.  It returns when the files should be published again, that is when
+// the first of the CRLs is due to be replaced; zero if there are no CRLs.
+func Publish(store *liftca.Store, dir string) (time.Time, error) {
+	var next time.Time
+	for _, ca := range publishedCAs(store) {
+		files := map[string][]byte{
+			CACertificateURL(ca.ID):           ca.DERCertificateBytes,
+			CAUrl(ca.ID) + "-certificate.pem": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.DERCertificateBytes}),
+		}
+		if chain, found := store.GetChain(ca.ID); found {
+			b, err := io.ReadAll(liftca.PEMChain(serverChain(chain)))
+			if err != nil {
+				return next, err
+			}
+			files[CAUrl(ca.ID)+"-chain.pem"] = b
+		}
+		crls := make(map[string]io.Reader)
+		if ca.HasPrivateKey() {
+			crl, err := store.DERCRL(ca.ID)
+			if err != nil {
+				return next, err
+			}
+			crls[CACRLFileURL(ca.ID)] = crl
+			if ca.DeltaCRLTTL() != 0 {
+				delta, err := store.DERDeltaCRL(ca.ID)
+				if err != nil {
+					return next, err
+				}
+				crls[CADeltaCRLFileURL(ca.ID)] = delta
+			}
+		}
+		for name, crl := range crls {
+			der, err := io.ReadAll(crl)
+			if err != nil {
+				return next, err
+			}
+			rl, err := x509.ParseRevocationList(der)
+			if err != nil {
+				return next, err
+			}
+			// The store replaces CRLs once half their validity is gone.
+			due := rl.ThisUpdate.Add(rl.NextUpdate.Sub(rl.ThisUpdate) / 2)
+			if next.IsZero() || due.Before(next) {
+				next = due
+			}
+			files[name] = der
+			files[strings.TrimSuffix(name, ".crl")+".pem"] = pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+		}
+		for name, content := range files {
+			if err := publishFile(filepath.Join(dir, filepath.FromSlash(name)), content); err != nil {
+				return next, err
+			}
+		}
+	}
+	return next, nil
+}
+
+// publishedCAs returns all the CAs of the store, invisible ones included:
+// the certificates they issued point to their files all the same.
+func publishedCAs(store *liftca.Store) []*liftca.Parcel {
+	cas := make([]*liftca.Parcel, 0)
+	seen := make(map[int64]bool)
+	queue := store.GetCAs()
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		p, found := store.Get(id)
+		if !found || !p.Certificate.IsCA {
+			continue
+		}
+		cas = append(cas, p)
+		children, _ := store.GetChildren(id)
+		queue = append(queue, children...)
+	}
+	return cas
+}
+
+// publishFile replaces the file at path with content, unless it already
+// holds it.  The content is written to a temporary file first, so that the
+// file is never seen half written.
+func publishFile(path string, content []byte) error {
+	if old, err := os.ReadFile(path); err == nil && bytes.Equal(old, content) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(content)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
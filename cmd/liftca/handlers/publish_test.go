@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"crypto/x509/pkix"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jeanfric/liftca"
+)
+
+// Publishing is triggered by the updates of the store; one that does not
+// change the store must not trigger another, or the server publishes in a
+// loop.
+func TestPublishWithoutChangeDoesNotSignalUpdates(t *testing.T) {
+	store := liftca.NewStore()
+	ca, err := store.AddCA(true, &liftca.CARequest{
+		Subject:  pkix.Name{CommonName: "root"},
+		KeyType:  liftca.KeyTypeECDSAP256,
+		Settings: liftca.CASettings{DeltaCRLTTL: 10 * time.Minute},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates := make(chan struct{}, 16)
+	store.Updates(updates)
+	dir := t.TempDir()
+
+	// The first publish issues the CRLs.
+	if _, err := Publish(store, dir); err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) == 0 {
+		t.Error("issuing CRLs signalled no update")
+	}
+	for len(updates) > 0 {
+		<-updates
+	}
+	for _, name := range []string{CACertificateURL(ca), CACRLFileURL(ca), CADeltaCRLFileURL(ca)} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			t.Error(err)
+		}
+	}
+
+	next, err := Publish(store, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(updates); n != 0 {
+		t.Errorf("publishing again signalled %v updates", n)
+	}
+	if !next.After(time.Now()) {
+		t.Errorf("next publish due at %v", next)
+	}
+}
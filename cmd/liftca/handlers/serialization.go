@@ -1,64 +1,706 @@
 package handlers
 
 import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jeanfric/liftca"
 )
 
+// JSONValidity is embedded in requests that accept a validity period.
+// Times are RFC 3339; the TTL is a Go duration such as '720h', or a number
+// of days such as '90d'.
+type JSONValidity struct {
+	NotBefore string `json:"notBefore"`
+	NotAfter  string `json:"notAfter"`
+	TTL       string `json:"ttl"`
+}
+
+// JSONName is a distinguished name.  Apart from the common name and serial
+// number, every attribute may appear more than once.
+type JSONName struct {
+	CommonName         string   `json:"commonName"`
+	Organization       []string `json:"organization"`
+	OrganizationalUnit []string `json:"organizationalUnit"`
+	Country            []string `json:"country"`
+	Province           []string `json:"province"`
+	Locality           []string `json:"locality"`
+	StreetAddress      []string `json:"streetAddress"`
+	PostalCode         []string `json:"postalCode"`
+	SerialNumber       string   `json:"serialNumber"`
+}
+
+// JSONNameConstraints restricts the names a CA may issue certificates for.
+// IP ranges are in CIDR notation, such as '10.1.0.0/16'.
+type JSONNameConstraints struct {
+	PermittedDNSDomains     []string `json:"permittedDNSDomains"`
+	ExcludedDNSDomains      []string `json:"excludedDNSDomains"`
+	PermittedIPRanges       []string `json:"permittedIPRanges"`
+	ExcludedIPRanges        []string `json:"excludedIPRanges"`
+	PermittedEmailAddresses []string `json:"permittedEmailAddresses"`
+	ExcludedEmailAddresses  []string `json:"excludedEmailAddresses"`
+}
+
+// JSONPolicy is a certificate policy, such as '2.23.140.1.2.1', with the
+// URIs of its certification practice statements.
+type JSONPolicy struct {
+	OID     string   `json:"oid"`
+	CPSURIs []string `json:"cpsURIs"`
+}
+
+// JSONExtension is an extension copied as is into certificates.  Its
+// DER-encoded value is given either in hexadecimal or in base64, as der.
+type JSONExtension struct {
+	OID      string `json:"oid"`
+	Critical bool   `json:"critical"`
+	Hex      string `json:"hex"`
+	DER      []byte `json:"der"`
+}
+
+// In responses, ID identifies the CA or certificate in liftCA's URLs, while
+// SerialNumber is that of the certificate.  They differ for imported
+// certificates.
 type JSONCAResponse struct {
-	Self         string `json:"self"`
-	SerialNumber string `json:"serialNumber"`
-	Name         string `json:"name"`
-	SubjectKeyID string `json:"subjectKeyID"`
-	Visible      bool   `json:"visible"`
+	Self               string              `json:"self"`
+	ID                 string              `json:"id"`
+	SerialNumber       string              `json:"serialNumber"`
+	Name               string              `json:"name"`
+	Subject            JSONName            `json:"subject"`
+	SubjectDN          string              `json:"subjectDN"`
+	SubjectKeyID       string              `json:"subjectKeyID"`
+	KeyType            string              `json:"keyType"`
+	NotBefore          time.Time           `json:"notBefore"`
+	NotAfter           time.Time           `json:"notAfter"`
+	DefaultCertTTL     string              `json:"defaultCertTTL"`
+	SignatureAlgorithm string              `json:"signatureAlgorithm"`
+	KeyIDMethod        string              `json:"keyIDMethod"`
+	CRLTTL             string              `json:"crlTTL"`
+	DeltaCRLTTL        string              `json:"deltaCRLTTL,omitempty"`
+	SerialPolicy       string              `json:"serialPolicy"`
+	DelegatedOCSP      bool                `json:"delegatedOCSP"`
+	MaxPathLen         int                 `json:"maxPathLen"`
+	NameConstraints    JSONNameConstraints `json:"nameConstraints"`
+	// ExtraNameConstraints are enforced by liftCA but absent from the
+	// certificate; only imported CAs have them.
+	ExtraNameConstraints JSONNameConstraints `json:"extraNameConstraints"`
+	Policies             []JSONPolicy        `json:"policies"`
+	Parent               string              `json:"parent,omitempty"`
+	ParentID             string              `json:"parentID,omitempty"`
+	SubCAs               string              `json:"subCAs"`
+	HasPrivateKey        bool                `json:"hasPrivateKey"`
+	Visible              bool                `json:"visible"`
+	// PredecessorID and SuccessorIDs link the generations of a rolled over
+	// root CA.
+	PredecessorID string   `json:"predecessorID,omitempty"`
+	SuccessorIDs  []string `json:"successorIDs"`
+	// CrossCertifiedID is set on cross certificates, to the CA whose key
+	// they certify; CrossCertificateIDs lists the cross certificates for
+	// the key of this CA.
+	CrossCertifiedID    string   `json:"crossCertifiedID,omitempty"`
+	CrossCertificateIDs []string `json:"crossCertificateIDs"`
+	// ChainRootIDs lists the roots this CA chains up to, directly or
+	// through cross certificates.
+	ChainRootIDs []string `json:"chainRootIDs"`
 }
 
 type JSONCARequest struct {
-	Visible        bool   `json:"visible"`
-	Name           string `json:"name"`
-	PEMCertificate string `json:"pemCertificate"`
-	PEMKey         string `json:"pemKey"`
-	PEMKeyPassword string `json:"pemKeyPassword"`
+	JSONValidity
+	Visible        bool     `json:"visible"`
+	Name           string   `json:"name"`
+	Subject        JSONName `json:"subject"`
+	KeyType        string   `json:"keyType"`
+	DefaultCertTTL string   `json:"defaultCertTTL"`
+	CRLTTL         string   `json:"crlTTL"`
+	DeltaCRLTTL    string   `json:"deltaCRLTTL"`
+	// SignatureAlgorithm and KeyIDMethod name a liftca.SignatureAlgorithm
+	// and a liftca.KeyIDMethod; both default to what the key calls for.
+	SignatureAlgorithm string `json:"signatureAlgorithm"`
+	KeyIDMethod        string `json:"keyIDMethod"`
+	// SerialPolicy names a liftca.SerialPolicy; it defaults to random.
+	SerialPolicy    string              `json:"serialPolicy"`
+	DelegatedOCSP   bool                `json:"delegatedOCSP"`
+	MaxPathLen      *int                `json:"maxPathLen"`
+	NameConstraints JSONNameConstraints `json:"nameConstraints"`
+	Policies        []JSONPolicy        `json:"policies"`
+	Extensions      []JSONExtension     `json:"extensions"`
+	PEMCertificate  string              `json:"pemCertificate"`
+	PEMKey          string              `json:"pemKey"`
+	PEMKeyPassword  string              `json:"pemKeyPassword"`
+	// PEMChain optionally holds the certificates of the issuers of an
+	// imported CA, from its own issuer up to the root.
+	PEMChain string `json:"pemChain"`
+	// PKCS12 holds a PKCS#12 (.p12 or .pfx) bundle with the certificate
+	// and private key of a CA to import, and optionally its issuers; its
+	// password is given in PEMKeyPassword.
+	PKCS12 []byte `json:"pkcs12"`
 }
 
+// JSONCRLRequest revokes the certificate ID.  SerialNumber is its former
+// name, still accepted.  Reason is a CRLReason name from RFC 5280, such as
+// 'keyCompromise'; RevokedBy defaults to the address of the client.
 type JSONCRLRequest struct {
-	SerialNumber string `json:"serialNumber"`
+	ID             string `json:"id"`
+	SerialNumber   string `json:"serialNumber"`
+	Reason         string `json:"reason"`
+	InvalidityDate string `json:"invalidityDate"`
+	RevokedBy      string `json:"revokedBy"`
 }
 
 type JSONCRLResponse struct {
-	Self          string   `json:"self"`
-	SerialNumbers []string `json:"serialNumbers"`
+	Self          string           `json:"self"`
+	IDs           []string         `json:"ids"`
+	SerialNumbers []string         `json:"serialNumbers"`
+	Revocations   []JSONRevocation `json:"revocations"`
+	// CRLNumber is the number of the last CRL issued, if any.
+	CRLNumber string `json:"crlNumber,omitempty"`
+	CRLTTL    string `json:"crlTTL"`
+	// DeltaCRLNumber is the number of the last delta CRL issued, if any.
+	DeltaCRLNumber string `json:"deltaCRLNumber,omitempty"`
+}
+
+// JSONRevocation is the revocation record of a certificate.
+type JSONRevocation struct {
+	ID             string     `json:"id"`
+	SerialNumber   string     `json:"serialNumber"`
+	Time           time.Time  `json:"time"`
+	Reason         string     `json:"reason"`
+	InvalidityDate *time.Time `json:"invalidityDate,omitempty"`
+	RevokedBy      string     `json:"revokedBy"`
 }
 
 type JSONCertRequest struct {
-	Host string `json:"host"`
+	JSONValidity
+	Host           string          `json:"host"`
+	Subject        JSONName        `json:"subject"`
+	DNSNames       []string        `json:"dnsNames"`
+	IPAddresses    []string        `json:"ipAddresses"`
+	EmailAddresses []string        `json:"emailAddresses"`
+	URIs           []string        `json:"uris"`
+	KeyType        string          `json:"keyType"`
+	Profile        string          `json:"profile"`
+	Policies       []JSONPolicy    `json:"policies"`
+	Extensions     []JSONExtension `json:"extensions"`
+}
+
+// JSONCSRRequest carries a PEM encoded PKCS#10 request; the names come from
+// the request itself.
+type JSONCSRRequest struct {
+	JSONValidity
+	CSR        string          `json:"csr"`
+	Profile    string          `json:"profile"`
+	Policies   []JSONPolicy    `json:"policies"`
+	Extensions []JSONExtension `json:"extensions"`
+}
+
+// JSONCrossSignRequest has a CA cross-sign the CA CAID.
+type JSONCrossSignRequest struct {
+	CAID string `json:"caID"`
+}
+
+// JSONRolloverRequest creates the next generation of a root CA.
+type JSONRolloverRequest struct {
+	JSONValidity
+	KeyType string `json:"keyType"`
+}
+
+// JSONRenewRequest renews a certificate, with a new key of type KeyType if
+// Rekey is set.
+type JSONRenewRequest struct {
+	JSONValidity
+	Rekey   bool   `json:"rekey"`
+	KeyType string `json:"keyType"`
+}
+
+// JSONCertImportRequest carries PEM encoded leaf certificates to import,
+// and optionally their PEM encoded private keys, in any order.  CAIDs names
+// the invisible CAs the certificates may be filed under.
+type JSONCertImportRequest struct {
+	PEMCertificates string   `json:"pemCertificates"`
+	PEMKeys         string   `json:"pemKeys"`
+	PEMKeyPassword  string   `json:"pemKeyPassword"`
+	CAIDs           []string `json:"caIDs"`
+}
+
+// JSONCertImportResponse reports the outcome of importing one certificate:
+// where it was stored, or why it was not.
+type JSONCertImportResponse struct {
+	SubjectDN    string `json:"subjectDN"`
+	SerialNumber string `json:"serialNumber"`
+	Self         string `json:"self,omitempty"`
+	ID           string `json:"id,omitempty"`
+	ParentID     string `json:"parentID,omitempty"`
+	Error        string `json:"error,omitempty"`
 }
 
 type JSONCertResponse struct {
-	Host           string `json:"host"`
-	Self           string `json:"self"`
-	SerialNumber   string `json:"serialNumber"`
-	SubjectKeyID   string `json:"subjectKeyID"`
-	AuthorityKeyID string `json:"authorityKeyID"`
+	Host                   string       `json:"host"`
+	Subject                JSONName     `json:"subject"`
+	SubjectDN              string       `json:"subjectDN"`
+	DNSNames               []string     `json:"dnsNames"`
+	IPAddresses            []string     `json:"ipAddresses"`
+	EmailAddresses         []string     `json:"emailAddresses"`
+	URIs                   []string     `json:"uris"`
+	Self                   string       `json:"self"`
+	ID                     string       `json:"id"`
+	SerialNumber           string       `json:"serialNumber"`
+	SubjectKeyID           string       `json:"subjectKeyID"`
+	AuthorityKeyID         string       `json:"authorityKeyID"`
+	KeyType                string       `json:"keyType"`
+	HasPrivateKey          bool         `json:"hasPrivateKey"`
+	Profile                string       `json:"profile"`
+	NotBefore              time.Time    `json:"notBefore"`
+	NotAfter               time.Time    `json:"notAfter"`
+	IssuingCertificateURLs []string     `json:"issuingCertificateURLs"`
+	CRLDistributionPoints  []string     `json:"crlDistributionPoints"`
+	OCSPServers            []string     `json:"ocspServers"`
+	Policies               []JSONPolicy `json:"policies"`
+	Predecessor            string       `json:"predecessor,omitempty"`
+	PredecessorID          string       `json:"predecessorID,omitempty"`
+	SuccessorIDs           []string     `json:"successorIDs"`
+	// ChainRootIDs lists the roots this certificate chains up to, directly
+	// or through cross certificates.
+	ChainRootIDs []string `json:"chainRootIDs"`
+}
+
+func (j *JSONName) Name() pkix.Name {
+	return pkix.Name{
+		CommonName:         j.CommonName,
+		Organization:       j.Organization,
+		OrganizationalUnit: j.OrganizationalUnit,
+		Country:            j.Country,
+		Province:           j.Province,
+		Locality:           j.Locality,
+		StreetAddress:      j.StreetAddress,
+		PostalCode:         j.PostalCode,
+		SerialNumber:       j.SerialNumber,
+	}
+}
+
+func JSONNameFromName(n pkix.Name) JSONName {
+	nonNil := func(values []string) []string {
+		return append(make([]string, 0), values...)
+	}
+	return JSONName{
+		CommonName:         n.CommonName,
+		Organization:       nonNil(n.Organization),
+		OrganizationalUnit: nonNil(n.OrganizationalUnit),
+		Country:            nonNil(n.Country),
+		Province:           nonNil(n.Province),
+		Locality:           nonNil(n.Locality),
+		StreetAddress:      nonNil(n.StreetAddress),
+		PostalCode:         nonNil(n.PostalCode),
+		SerialNumber:       n.SerialNumber,
+	}
+}
+
+func parseTTL(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid ttl '%v'", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func (j *JSONValidity) Validity() (v liftca.Validity, err error) {
+	if v.NotBefore, err = parseTime(j.NotBefore); err != nil {
+		return
+	}
+	if v.NotAfter, err = parseTime(j.NotAfter); err != nil {
+		return
+	}
+	v.TTL, err = parseTTL(j.TTL)
+	return
+}
+
+func parseIPRanges(ranges []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(ranges))
+	for _, s := range ranges {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP range '%v'", s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func (j *JSONNameConstraints) NameConstraints() (c liftca.NameConstraints, err error) {
+	if c.PermittedIPRanges, err = parseIPRanges(j.PermittedIPRanges); err != nil {
+		return
+	}
+	if c.ExcludedIPRanges, err = parseIPRanges(j.ExcludedIPRanges); err != nil {
+		return
+	}
+	c.PermittedDNSDomains = j.PermittedDNSDomains
+	c.ExcludedDNSDomains = j.ExcludedDNSDomains
+	c.PermittedEmailAddresses = j.PermittedEmailAddresses
+	c.ExcludedEmailAddresses = j.ExcludedEmailAddresses
+	return
+}
+
+func JSONNameConstraintsFromNameConstraints(c liftca.NameConstraints) JSONNameConstraints {
+	nonNil := func(values []string) []string {
+		return append(make([]string, 0), values...)
+	}
+	ranges := func(nets []*net.IPNet) []string {
+		s := make([]string, len(nets))
+		for i, n := range nets {
+			s[i] = n.String()
+		}
+		return s
+	}
+	return JSONNameConstraints{
+		PermittedDNSDomains:     nonNil(c.PermittedDNSDomains),
+		ExcludedDNSDomains:      nonNil(c.ExcludedDNSDomains),
+		PermittedIPRanges:       ranges(c.PermittedIPRanges),
+		ExcludedIPRanges:        ranges(c.ExcludedIPRanges),
+		PermittedEmailAddresses: nonNil(c.PermittedEmailAddresses),
+		ExcludedEmailAddresses:  nonNil(c.ExcludedEmailAddresses),
+	}
+}
+
+func parseOID(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(s, ".")
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid OID '%v'", s)
+		}
+		oid[i] = n
+	}
+	if len(oid) < 2 {
+		return nil, fmt.Errorf("invalid OID '%v'", s)
+	}
+	return oid, nil
+}
+
+func parsePolicies(policies []JSONPolicy) ([]liftca.Policy, error) {
+	parsed := make([]liftca.Policy, len(policies))
+	for i, p := range policies {
+		oid, err := parseOID(p.OID)
+		if err != nil {
+			return nil, err
+		}
+		parsed[i] = liftca.Policy{OID: oid, CPSURIs: p.CPSURIs}
+	}
+	return parsed, nil
+}
+
+func parseExtensions(extensions []JSONExtension) ([]liftca.Extension, error) {
+	parsed := make([]liftca.Extension, len(extensions))
+	for i, e := range extensions {
+		oid, err := parseOID(e.OID)
+		if err != nil {
+			return nil, err
+		}
+		value := e.DER
+		if e.Hex != "" {
+			if len(e.DER) > 0 {
+				return nil, fmt.Errorf("extension %v has both a hex and a der value", e.OID)
+			}
+			value, err = hex.DecodeString(strings.Join(strings.Fields(strings.Replace(e.Hex, ":", "", -1)), ""))
+			if err != nil {
+				return nil, fmt.Errorf("invalid hex value for extension %v", e.OID)
+			}
+		}
+		parsed[i] = liftca.Extension{OID: oid, Critical: e.Critical, Value: value}
+	}
+	return parsed, nil
+}
+
+func JSONPoliciesFromPolicies(policies []liftca.Policy) []JSONPolicy {
+	j := make([]JSONPolicy, len(policies))
+	for i, p := range policies {
+		j[i] = JSONPolicy{OID: p.OID.String(), CPSURIs: append(make([]string, 0), p.CPSURIs...)}
+	}
+	return j
+}
+
+// CASettings converts the per-CA settings of the JSON request.
+func (j *JSONCARequest) CASettings() (settings liftca.CASettings, err error) {
+	settings.DefaultTTL, err = parseTTL(j.DefaultCertTTL)
+	if err != nil {
+		return
+	}
+	settings.CRLTTL, err = parseTTL(j.CRLTTL)
+	if err != nil {
+		return
+	}
+	settings.DeltaCRLTTL, err = parseTTL(j.DeltaCRLTTL)
+	if err != nil {
+		return
+	}
+	settings.SignatureAlgorithm = liftca.SignatureAlgorithm(j.SignatureAlgorithm)
+	settings.KeyIDMethod = liftca.KeyIDMethod(j.KeyIDMethod)
+	settings.SerialPolicy = liftca.SerialPolicy(j.SerialPolicy)
+	settings.DelegatedOCSP = j.DelegatedOCSP
+	return
+}
+
+// Revocation converts the revocation details of the JSON request.
+func (j *JSONCRLRequest) Revocation() (r liftca.Revocation, err error) {
+	if r.Reason, err = liftca.ParseRevocationReason(j.Reason); err != nil {
+		return
+	}
+	if r.InvalidityDate, err = parseTime(j.InvalidityDate); err != nil {
+		return
+	}
+	r.RevokedBy = j.RevokedBy
+	return
+}
+
+// JSONRevocationFromRevocation describes the revocation r of cert.
+func JSONRevocationFromRevocation(cert *liftca.Parcel, r liftca.Revocation) JSONRevocation {
+	j := JSONRevocation{
+		ID:           strconv.FormatInt(cert.ID, 10),
+		SerialNumber: cert.SerialNumber().String(),
+		Time:         r.Time,
+		Reason:       r.Reason.String(),
+		RevokedBy:    r.RevokedBy,
+	}
+	if !r.InvalidityDate.IsZero() {
+		j.InvalidityDate = &r.InvalidityDate
+	}
+	return j
+}
+
+// CARequest converts the JSON request to a liftca.CARequest.
+func (j *JSONCARequest) CARequest() (*liftca.CARequest, error) {
+	validity, err := j.Validity()
+	if err != nil {
+		return nil, err
+	}
+	settings, err := j.CASettings()
+	if err != nil {
+		return nil, err
+	}
+	constraints, err := j.NameConstraints.NameConstraints()
+	if err != nil {
+		return nil, err
+	}
+	policies, err := parsePolicies(j.Policies)
+	if err != nil {
+		return nil, err
+	}
+	extensions, err := parseExtensions(j.Extensions)
+	if err != nil {
+		return nil, err
+	}
+	subject := j.Subject.Name()
+	if subject.CommonName == "" {
+		subject.CommonName = j.Name
+	}
+	return &liftca.CARequest{
+		Subject:         subject,
+		KeyType:         liftca.KeyType(j.KeyType),
+		Validity:        validity,
+		MaxPathLen:      j.MaxPathLen,
+		NameConstraints: constraints,
+		Policies:        policies,
+		Extensions:      extensions,
+		Settings:        settings,
+	}, nil
+}
+
+// CertRequest converts the JSON request to a liftca.CertRequest.  For
+// backwards compatibility, Host may hold either an IP address or a DNS name;
+// it is placed first in the matching list.
+func (j *JSONCertRequest) CertRequest() (*liftca.CertRequest, error) {
+	validity, err := j.Validity()
+	if err != nil {
+		return nil, err
+	}
+	policies, err := parsePolicies(j.Policies)
+	if err != nil {
+		return nil, err
+	}
+	extensions, err := parseExtensions(j.Extensions)
+	if err != nil {
+		return nil, err
+	}
+	req := &liftca.CertRequest{
+		Subject:        j.Subject.Name(),
+		Policies:       policies,
+		Extensions:     extensions,
+		Validity:       validity,
+		KeyType:        liftca.KeyType(j.KeyType),
+		Profile:        liftca.Profile(j.Profile),
+		DNSNames:       make([]string, 0),
+		IPAddresses:    make([]net.IP, 0),
+		EmailAddresses: j.EmailAddresses,
+		URIs:           make([]*url.URL, 0),
+	}
+	if j.Host != "" {
+		if ip := net.ParseIP(j.Host); ip != nil {
+			req.IPAddresses = append(req.IPAddresses, ip)
+		} else {
+			req.DNSNames = append(req.DNSNames, j.Host)
+		}
+	}
+	req.DNSNames = append(req.DNSNames, j.DNSNames...)
+	for _, s := range j.IPAddresses {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address '%v'", s)
+		}
+		req.IPAddresses = append(req.IPAddresses, ip)
+	}
+	for _, s := range j.URIs {
+		uri, err := url.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		req.URIs = append(req.URIs, uri)
+	}
+	return req, nil
+}
+
+func (j *JSONRolloverRequest) RolloverRequest() (*liftca.RolloverRequest, error) {
+	validity, err := j.Validity()
+	if err != nil {
+		return nil, err
+	}
+	return &liftca.RolloverRequest{
+		KeyType:  liftca.KeyType(j.KeyType),
+		Validity: validity,
+	}, nil
+}
+
+func (j *JSONRenewRequest) RenewRequest() (*liftca.RenewRequest, error) {
+	validity, err := j.Validity()
+	if err != nil {
+		return nil, err
+	}
+	return &liftca.RenewRequest{
+		Rekey:    j.Rekey,
+		KeyType:  liftca.KeyType(j.KeyType),
+		Validity: validity,
+	}, nil
+}
+
+func (j *JSONCSRRequest) CertRequest() (*liftca.CertRequest, error) {
+	validity, err := j.Validity()
+	if err != nil {
+		return nil, err
+	}
+	policies, err := parsePolicies(j.Policies)
+	if err != nil {
+		return nil, err
+	}
+	extensions, err := parseExtensions(j.Extensions)
+	if err != nil {
+		return nil, err
+	}
+	return &liftca.CertRequest{
+		Validity:   validity,
+		Profile:    liftca.Profile(j.Profile),
+		Policies:   policies,
+		Extensions: extensions,
+	}, nil
 }
 
 func JSONCAResponseFromParcel(p *liftca.Parcel) *JSONCAResponse {
+	deltaCRLTTL := ""
+	if ttl := p.DeltaCRLTTL(); ttl != 0 {
+		deltaCRLTTL = ttl.String()
+	}
 	return &JSONCAResponse{
-		Name:         p.Certificate.Subject.CommonName,
-		Self:         CAUrl(p.SerialNumber()),
-		SerialNumber: strconv.FormatInt(p.SerialNumber(), 10),
-		SubjectKeyID: p.SubjectKeyID(),
-		Visible:      p.Visible,
+		Name:                 p.Certificate.Subject.CommonName,
+		Subject:              JSONNameFromName(p.Certificate.Subject),
+		SubjectDN:            p.Certificate.Subject.String(),
+		Self:                 CAUrl(p.ID),
+		ID:                   strconv.FormatInt(p.ID, 10),
+		SerialNumber:         p.SerialNumber().String(),
+		SubjectKeyID:         p.SubjectKeyID(),
+		KeyType:              string(p.KeyType()),
+		NotBefore:            p.Certificate.NotBefore,
+		NotAfter:             p.Certificate.NotAfter,
+		DefaultCertTTL:       p.DefaultCertTTL().String(),
+		SignatureAlgorithm:   string(p.SignatureAlgorithm()),
+		KeyIDMethod:          string(p.KeyIDMethod()),
+		CRLTTL:               p.CRLTTL().String(),
+		DeltaCRLTTL:          deltaCRLTTL,
+		SerialPolicy:         string(p.SerialPolicy()),
+		DelegatedOCSP:        p.DelegatedOCSP(),
+		MaxPathLen:           p.Certificate.MaxPathLen,
+		NameConstraints:      JSONNameConstraintsFromNameConstraints(p.NameConstraints()),
+		ExtraNameConstraints: JSONNameConstraintsFromNameConstraints(p.ExtraNameConstraints()),
+		Policies:             JSONPoliciesFromPolicies(p.Policies()),
+		SubCAs:               SubCAsURL(p.ID),
+		HasPrivateKey:        p.HasPrivateKey(),
+		Visible:              p.Visible,
+		SuccessorIDs:         make([]string, 0),
+		CrossCertificateIDs:  make([]string, 0),
 	}
 }
 
 func JSONCertResponseFromParcel(caId int64, p *liftca.Parcel) *JSONCertResponse {
+	ips := make([]string, len(p.Certificate.IPAddresses))
+	for i, ip := range p.Certificate.IPAddresses {
+		ips[i] = ip.String()
+	}
+	uris := make([]string, len(p.Certificate.URIs))
+	for i, uri := range p.Certificate.URIs {
+		uris[i] = uri.String()
+	}
 	return &JSONCertResponse{
-		Host:           p.Host(),
-		Self:           CertUrl(caId, p.SerialNumber()),
-		SerialNumber:   strconv.FormatInt(p.SerialNumber(), 10),
-		SubjectKeyID:   p.SubjectKeyID(),
-		AuthorityKeyID: p.AuthorityKeyID(),
+		Host:                   p.Host(),
+		Subject:                JSONNameFromName(p.Certificate.Subject),
+		SubjectDN:              p.Certificate.Subject.String(),
+		DNSNames:               append(make([]string, 0), p.Certificate.DNSNames...),
+		IPAddresses:            ips,
+		EmailAddresses:         append(make([]string, 0), p.Certificate.EmailAddresses...),
+		URIs:                   uris,
+		Self:                   CertUrl(caId, p.ID),
+		ID:                     strconv.FormatInt(p.ID, 10),
+		SerialNumber:           p.SerialNumber().String(),
+		SubjectKeyID:           p.SubjectKeyID(),
+		AuthorityKeyID:         p.AuthorityKeyID(),
+		KeyType:                string(p.KeyType()),
+		HasPrivateKey:          p.HasPrivateKey(),
+		Profile:                string(p.Profile()),
+		NotBefore:              p.Certificate.NotBefore,
+		NotAfter:               p.Certificate.NotAfter,
+		IssuingCertificateURLs: append(make([]string, 0), p.Certificate.IssuingCertificateURL...),
+		CRLDistributionPoints:  append(make([]string, 0), p.Certificate.CRLDistributionPoints...),
+		OCSPServers:            append(make([]string, 0), p.Certificate.OCSPServer...),
+		Policies:               JSONPoliciesFromPolicies(p.Policies()),
+		SuccessorIDs:           make([]string, 0),
+	}
+}
+
+func JSONCertImportResponseFromImportedCert(c liftca.ImportedCert) JSONCertImportResponse {
+	response := JSONCertImportResponse{
+		SubjectDN:    c.Certificate.Subject.String(),
+		SerialNumber: c.Certificate.SerialNumber.String(),
+	}
+	if c.Err != nil {
+		response.Error = c.Err.Error()
+		return response
 	}
+	response.Self = CertUrl(c.ParentID, c.ID)
+	response.ID = strconv.FormatInt(c.ID, 10)
+	response.ParentID = strconv.FormatInt(c.ParentID, 10)
+	return response
 }
@@ -2,8 +2,10 @@ package handlers
 
 import (
 	"fmt"
+	"net/url"
 	"path"
 	"strconv"
+	"strings"
 
 	"github.com/jeanfric/liftca"
 	"github.com/jeanfric/liftca/ht"
@@ -18,10 +20,55 @@ func CAUrl(caSerial int64) string {
 	return path.Join("/", CaFolder, strconv.FormatInt(caSerial, 10))
 }
 
+func SubCAsURL(caSerial int64) string {
+	return path.Join(CAUrl(caSerial), CaFolder)
+}
+
 func CACRLURL(caSerial int64) string {
 	return path.Join(CAUrl(caSerial), "crl")
 }
 
+func CACertificateURL(caSerial int64) string {
+	return CAUrl(caSerial) + "-certificate.cer"
+}
+
+func CACRLFileURL(caSerial int64) string {
+	return CAUrl(caSerial) + "-crl.crl"
+}
+
+func CADeltaCRLFileURL(caSerial int64) string {
+	return CAUrl(caSerial) + "-delta-crl.crl"
+}
+
+func CAOCSPURL(caSerial int64) string {
+	return path.Join(CAUrl(caSerial), "ocsp")
+}
+
+// Distribution returns the distribution points of CAs served by liftCA at
+// baseURL, such as 'http://pki.lab:8080'.  The OCSP responder is only
+// advertised when ocsp is set.
+func Distribution(baseURL string, ocsp bool) (func(caSerial int64) liftca.DistributionPoints, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid base URL '%v': must be an absolute HTTP URL", baseURL)
+	}
+	base := strings.TrimSuffix(baseURL, "/")
+	return func(caSerial int64) liftca.DistributionPoints {
+		dp := liftca.DistributionPoints{
+			IssuingCertificateURL: base + CACertificateURL(caSerial),
+			CRLURL:                base + CACRLFileURL(caSerial),
+			DeltaCRLURL:           base + CADeltaCRLFileURL(caSerial),
+		}
+		if ocsp {
+			dp.OCSPServer = base + CAOCSPURL(caSerial)
+		}
+		return dp
+	}, nil
+}
+
 func CertUrl(caSerial, certSerial int64) string {
 	return path.Join("/", CaFolder, strconv.FormatInt(caSerial, 10), CertFolder, strconv.FormatInt(certSerial, 10))
 }
@@ -32,15 +79,55 @@ func ObtainCA(store *liftca.Store, r *ht.Request) (*liftca.Parcel, *ht.Answer) {
 		return nil, ht.Failure(err)
 	}
 	auth, found := store.Get(caID)
-	if !found {
+	if !found || !auth.Certificate.IsCA {
 		return nil, ht.NotFound()
 	}
 
-	if _, found := store.GetParent(caID); found {
+	return auth, nil
+}
+
+// ObtainIssuingCA is ObtainCA for the routes that have the CA sign: CAs
+// without a private key, such as cross certificates, are not found there.
+func ObtainIssuingCA(store *liftca.Store, r *ht.Request) (*liftca.Parcel, *ht.Answer) {
+	ca, answer := ObtainCA(store, r)
+	if answer != nil {
+		return nil, answer
+	}
+	if !ca.HasPrivateKey() {
 		return nil, ht.NotFound()
 	}
+	return ca, nil
+}
 
-	return auth, nil
+// ObtainChain returns the parcel id followed by its issuers, leaving out a
+// self-signed root: that is, the chain a server presents to its clients.
+// The optional "root" query parameter picks, among the chains going through
+// cross certificates, the one ending at that root.
+func ObtainChain(store *liftca.Store, r *ht.Request, id int64) ([]*liftca.Parcel, *ht.Answer) {
+	var chain []*liftca.Parcel
+	if root := r.Query("root"); root != "" {
+		for _, c := range store.GetChains(id) {
+			if strconv.FormatInt(c[len(c)-1].ID, 10) == root {
+				chain = c
+				break
+			}
+		}
+	} else {
+		chain, _ = store.GetChain(id)
+	}
+	if len(chain) == 0 {
+		return nil, ht.NotFound()
+	}
+	return serverChain(chain), nil
+}
+
+// serverChain leaves out the self-signed root that ends chain, unless it is
+// the only certificate.
+func serverChain(chain []*liftca.Parcel) []*liftca.Parcel {
+	if len(chain) > 1 && chain[len(chain)-1].IsSelfSigned() {
+		return chain[:len(chain)-1]
+	}
+	return chain
 }
 
 func ObtainCAAndCert(store *liftca.Store, r *ht.Request) (*liftca.Parcel, *liftca.Parcel, *ht.Answer) {
@@ -58,9 +145,23 @@ func ObtainCAAndCert(store *liftca.Store, r *ht.Request) (*liftca.Parcel, *liftc
 		return nil, nil, ht.NotFound()
 	}
 	parent, _ := store.GetParent(certID)
-	if parent != ca.SerialNumber() {
-		return nil, nil, ht.Failure(fmt.Errorf("certificate %v does not belong to CA %v", certID, ca.SerialNumber()))
+	if parent != ca.ID {
+		return nil, nil, ht.Failure(fmt.Errorf("certificate %v does not belong to CA %v", certID, ca.ID))
 	}
 
 	return ca, cert, nil
 }
+
+// chainRootIDs returns the ids of the roots that the chains of id end at.
+func chainRootIDs(store *liftca.Store, id int64) []string {
+	ids := make([]string, 0)
+	seen := make(map[int64]bool)
+	for _, chain := range store.GetChains(id) {
+		root := chain[len(chain)-1].ID
+		if !seen[root] {
+			seen[root] = true
+			ids = append(ids, strconv.FormatInt(root, 10))
+		}
+	}
+	return ids
+}
@@ -4,6 +4,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -22,12 +23,32 @@ func main() {
 	var addressArg string
 	var storeFileArg string
 	var serveDir string
+	var baseURLArg string
+	var ocspArg bool
+	var publishDirArg string
 
 	flag.StringVar(&addressArg, "a", ":8080", "listen address")
 	flag.StringVar(&storeFileArg, "s", "store.gob", "path to state storage file")
 	flag.StringVar(&serveDir, "d", "", "if set, directory to serve static assets from; else use embedded assets")
+	flag.StringVar(&baseURLArg, "url", "", "public base URL of liftCA (e.g. 'http://pki.lab:8080'); if set, issued certificates point there for their issuer's certificate and CRL")
+	flag.BoolVar(&ocspArg, "ocsp", false, "with -url, also point issued certificates to the built-in OCSP responder")
+	flag.StringVar(&publishDirArg, "publish", "", "if set, directory to keep CA certificates, chains and CRLs in, under the paths liftCA serves them from, for any web server to serve")
+	keyBarrelSizes := make(map[liftca.KeyType]*int)
+	for _, t := range liftca.KeyTypes() {
+		size := 0
+		if t == liftca.DefaultKeyType {
+			size = 8
+		}
+		keyBarrelSizes[t] = flag.Int("keys-"+string(t), size, fmt.Sprintf("number of pre-generated %v keys to keep ready", t))
+	}
 	flag.Parse()
 
+	for t, size := range keyBarrelSizes {
+		if err := liftca.StartKeyBarrel(t, *size); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	storeFile := filepath.Clean(storeFileArg)
 	backingFile, err := os.OpenFile(storeFile, os.O_CREATE|os.O_RDWR, 0666)
 	if err != nil {
@@ -35,6 +56,15 @@ func main() {
 	}
 	store := liftca.LoadStore(backingFile)
 	backingFile.Close()
+	if baseURLArg != "" {
+		distribution, err := handlers.Distribution(baseURLArg, ocspArg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		store.SetDistribution(distribution)
+	} else if ocspArg {
+		log.Fatal("-ocsp requires -url")
+	}
 	storeChanged := make(chan struct{})
 	store.Updates(storeChanged)
 	go func(path string, c <-chan struct{}) {
@@ -57,6 +87,41 @@ func main() {
 		}
 	}(storeFileArg, storeChanged)
 
+	if publishDirArg != "" {
+		// Publishing issues CRLs, which changes the store in turn: changes
+		// are noted while publishing, rather than waited on.
+		publishChanged := make(chan struct{})
+		store.Updates(publishChanged)
+		pending := make(chan struct{}, 1)
+		pending <- struct{}{}
+		go func() {
+			for range publishChanged {
+				select {
+				case pending <- struct{}{}:
+				default:
+				}
+			}
+		}()
+		go func(dir string) {
+			var due <-chan time.Time
+			for {
+				select {
+				case <-pending:
+				case <-due:
+				}
+				next, err := handlers.Publish(store, dir)
+				if err != nil {
+					log.Printf("unable to publish to '%v': %v", dir, err)
+					next = time.Now().Add(time.Minute)
+				}
+				due = nil
+				if !next.IsZero() {
+					due = time.After(time.Until(next))
+				}
+			}
+		}(filepath.Clean(publishDirArg))
+	}
+
 	var fileServer http.Handler
 
 	if serveDir != "" {
@@ -74,7 +139,14 @@ func main() {
 
 	r.Handle("GET", "/ca", ht.NewHandler(store, handlers.GetCAs))
 	r.Handle("POST", "/ca", ht.NewHandler(store, handlers.PostCA))
+	r.Handle("POST", "/cert", ht.NewHandler(store, handlers.PostCerts))
 	r.Handle("GET", "/ca/{ca_id}-certificate.cer", ht.NewHandler(store, handlers.GetCACertificateCER))
+	r.Handle("GET", "/ca/{ca_id}-chain.pem", ht.NewHandler(store, handlers.GetCAChainPEM))
+	r.Handle("GET", "/ca/{ca_id}-chain.pem.txt", ht.NewHandler(store, handlers.GetCAChainPEMTXT))
+	// Delta CRL routes come first: "{ca_id}-crl.crl" would match them too.
+	r.Handle("GET", "/ca/{ca_id}-delta-crl.crl", ht.NewHandler(store, handlers.GetCADeltaCRLCER))
+	r.Handle("GET", "/ca/{ca_id}-delta-crl.pem", ht.NewHandler(store, handlers.GetCADeltaCRLPEM))
+	r.Handle("GET", "/ca/{ca_id}-delta-crl.pem.txt", ht.NewHandler(store, handlers.GetCADeltaCRLPEMTXT))
 	r.Handle("GET", "/ca/{ca_id}-crl.crl", ht.NewHandler(store, handlers.GetCACRLCER))
 	r.Handle("GET", "/ca/{ca_id}-private-key.cer", ht.NewHandler(store, handlers.GetCAPrivateKeyCER))
 	r.Handle("GET", "/ca/{ca_id}-certificate.pem", ht.NewHandler(store, handlers.GetCACertificatePEM))
@@ -84,18 +156,29 @@ func main() {
 	r.Handle("GET", "/ca/{ca_id}-crl.pem", ht.NewHandler(store, handlers.GetCACRLPEM))
 	r.Handle("GET", "/ca/{ca_id}-crl.pem.txt", ht.NewHandler(store, handlers.GetCACRLPEMTXT))
 	r.Handle("GET", "/ca/{ca_id}", ht.NewHandler(store, handlers.GetCA))
+	r.Handle("GET", "/ca/{ca_id}/ca", ht.NewHandler(store, handlers.GetSubCAs))
+	r.Handle("POST", "/ca/{ca_id}/ca", ht.NewHandler(store, handlers.PostSubCA))
+	r.Handle("POST", "/ca/{ca_id}/cross", ht.NewHandler(store, handlers.PostCrossSign))
+	r.Handle("POST", "/ca/{ca_id}/rollover", ht.NewHandler(store, handlers.PostRollover))
 	r.Handle("GET", "/ca/{ca_id}/cert", ht.NewHandler(store, handlers.GetCerts))
 	r.Handle("POST", "/ca/{ca_id}/cert", ht.NewHandler(store, handlers.PostCert))
 	r.Handle("GET", "/ca/{ca_id}/cert/{cert_id}-certificate.pem", ht.NewHandler(store, handlers.GetCertificatePEM))
 	r.Handle("GET", "/ca/{ca_id}/cert/{cert_id}-certificate.pem.txt", ht.NewHandler(store, handlers.GetCertificatePEMTXT))
+	r.Handle("POST", "/ca/{ca_id}/csr", ht.NewHandler(store, handlers.PostCSR))
+	r.Handle("GET", "/ca/{ca_id}/cert/{cert_id}-chain.pem", ht.NewHandler(store, handlers.GetCertificateChainPEM))
+	r.Handle("GET", "/ca/{ca_id}/cert/{cert_id}-chain.pem.txt", ht.NewHandler(store, handlers.GetCertificateChainPEMTXT))
 	r.Handle("GET", "/ca/{ca_id}/cert/{cert_id}-private-key.pem", ht.NewHandler(store, handlers.GetCertificatePrivateKeyPEM))
 	r.Handle("GET", "/ca/{ca_id}/cert/{cert_id}-private-key.pem.txt", ht.NewHandler(store, handlers.GetCertificatePrivateKeyPEMTXT))
 	r.Handle("GET", "/ca/{ca_id}/cert/{cert_id}-private-key.cer", ht.NewHandler(store, handlers.GetCertificatePrivateKeyCER))
 	r.Handle("GET", "/ca/{ca_id}/cert/{cert_id}-certificate.cer", ht.NewHandler(store, handlers.GetCertificateCER))
+	r.Handle("GET", "/ca/{ca_id}/cert/{cert_id}-ocsp.der", ht.NewHandler(store, handlers.GetCertificateOCSPDER))
 	r.Handle("GET", "/ca/{ca_id}/cert/{cert_id}", ht.NewHandler(store, handlers.GetCert))
+	r.Handle("POST", "/ca/{ca_id}/cert/{cert_id}/renew", ht.NewHandler(store, handlers.PostRenew))
 	r.Handle("POST", "/ca/{ca_id}/crl", ht.NewHandler(store, handlers.PostCRL))
 	r.Handle("GET", "/ca/{ca_id}/crl", ht.NewHandler(store, handlers.GetCRL))
 	r.Handle("DELETE", "/ca/{ca_id}/crl/{cert_id}", ht.NewHandler(store, handlers.DeleteCRL))
+	r.Handle("POST", "/ca/{ca_id}/ocsp", ht.NewHandler(store, handlers.PostOCSP))
+	r.Handle("GET", "/ca/{ca_id}/ocsp/{request:.+}", ht.NewHandler(store, handlers.GetOCSP))
 	r.Handle("GET", "/", fileServer)
 	r.Handle("GET", "/{f}", fileServer)
 	r.Handle("GET", "/js/{f}", fileServer)
@@ -9,18 +9,87 @@ microcaApp.config(
              when('/contact', {templateUrl: 'partials/contact.html'}).
              when('/ca', {templateUrl: 'partials/ca-list.html', controller: 'caListCtrl'}).
              when('/importca', {templateUrl: 'partials/ca-import.html', controller: 'caImportCtrl'}).
+             when('/importcerts', {templateUrl: 'partials/cert-import.html', controller: 'certImportCtrl'}).
              when('/ca/:caId', {templateUrl: 'partials/ca-detail.html', controller: 'caDetailCtrl'}).
              when('/ca/:caId/cert/:certId', {templateUrl: 'partials/cert.html', controller: 'certDetailCtrl'}).
              otherwise({redirectTo: '/ca'});
      }]);
 
+var splitList = function(text) {
+    return _((text || '').split(',')).chain()
+        .map(function(s) { return s.trim(); })
+        .compact()
+        .value();
+};
+
+// subjectFromForm turns the single-valued subject fields of a form into the
+// lists expected by the API.
+var subjectFromForm = function(form) {
+    var subject = {};
+    _(form || {}).each(function(value, key) {
+        if (key === 'commonName' || key === 'serialNumber') {
+            subject[key] = value;
+        } else if (value) {
+            subject[key] = [value];
+        }
+    });
+    return subject;
+};
+
+// nameConstraintsFromForm sorts the comma-separated permitted and excluded
+// names of a form: ranges such as '10.0.0.0/8' are IP ranges, names with an
+// '@' are email addresses or, as in '@team.lab', email domains, and the rest
+// are DNS domains.
+var nameConstraintsFromForm = function(form) {
+    var constraints = {};
+    _(['permitted', 'excluded']).each(function(kind) {
+        var names = splitList((form || {})[kind]);
+        constraints[kind + 'DNSDomains'] = _(names).filter(function(n) {
+            return n.indexOf('/') < 0 && n.indexOf('@') < 0;
+        });
+        constraints[kind + 'IPRanges'] = _(names).filter(function(n) {
+            return n.indexOf('/') >= 0;
+        });
+        constraints[kind + 'EmailAddresses'] = _(names).chain()
+            .filter(function(n) { return n.indexOf('@') >= 0; })
+            .map(function(n) { return n.charAt(0) === '@' ? n.substring(1) : n; })
+            .value();
+    });
+    return constraints;
+};
+
+// splitLines returns the non-empty lines of text, each split into words.
+var splitLines = function(text) {
+    return _((text || '').split('\n')).chain()
+        .map(function(line) { return _(line.trim().split(/\s+/)).compact(); })
+        .filter(function(words) { return words.length > 0; })
+        .value();
+};
+
+// policiesFromForm reads one policy per line: its OID, then the URIs of its
+// certification practice statements.
+var policiesFromForm = function(text) {
+    return _(splitLines(text)).map(function(words) {
+        return {oid: words[0], cpsURIs: words.slice(1)};
+    });
+};
+
+// extensionsFromForm reads one extension per line: its OID, optionally the
+// word 'critical', then its DER value in hexadecimal.
+var extensionsFromForm = function(text) {
+    return _(splitLines(text)).map(function(words) {
+        var critical = words[1] === 'critical';
+        return {oid: words[0], critical: critical, hex: words.slice(critical ? 2 : 1).join('')};
+    });
+};
+
 microcaApp.controller(
     'caListCtrl', 
     function caListCtrl($scope, $http, $location) {
 
         $scope.predicate = "name";
         $scope.reverse = false;
-        $scope.ca = {"visible": true};
+        $scope.ca = {"visible": true, "keyType": "rsa-2048"};
 
         var fetch = function() {
             $http.get('ca').success(function(data) {
@@ -30,10 +99,16 @@ microcaApp.controller(
         fetch();
         
         $scope.generateCA = function(ca) {
+            var req = _.extend({}, ca, {
+                subject: subjectFromForm(ca.subject),
+                nameConstraints: nameConstraintsFromForm(ca.nameConstraints),
+                policies: policiesFromForm(ca.policies),
+                extensions: extensionsFromForm(ca.extensions)
+            });
             $http
-                .post('ca', ca)
+                .post('ca', req)
                 .success(function(data) {
-                    $location.path('/ca/' + data.serialNumber)
+                    $location.path('/ca/' + data.id)
                 });
         }
 });
@@ -42,11 +117,40 @@ microcaApp.controller(
     'caImportCtrl',
     function caImportCtrl($scope, $http, $location) {
         $scope.ca = {"visible": true};
+        $scope.readPKCS12 = function(file) {
+            var reader = new FileReader();
+            reader.onload = function() {
+                $scope.$apply(function() {
+                    // Strip the "data:...;base64," prefix of the data URL.
+                    $scope.ca.pkcs12 = reader.result.substring(reader.result.indexOf(',') + 1);
+                });
+            };
+            if (file) {
+                reader.readAsDataURL(file);
+            } else {
+                delete $scope.ca.pkcs12;
+            }
+        };
         $scope.importCA = function(ca) {
+             var req = _.extend({}, ca, {nameConstraints: nameConstraintsFromForm(ca.nameConstraints)});
+             $http
+                 .post('ca', req)
+                 .success(function(data) {
+                     $location.path('/ca/' + data.id)
+                 });
+        }
+    }
+);
+
+microcaApp.controller(
+    'certImportCtrl',
+    function certImportCtrl($scope, $http) {
+        $scope.certs = {};
+        $scope.importCerts = function(certs) {
              $http
-                 .post('ca', ca)
+                 .post('cert', _({}).extend(certs, {caIDs: splitList(certs.caIDs)}))
                  .success(function(data) {
-                     $location.path('/ca/' + data.serialNumber)
+                     $scope.results = data;
                  });
         }
     }
@@ -58,30 +162,108 @@ microcaApp.controller(
 
         $scope.predicate = "host";
         $scope.reverse = false;
+        $scope.cert = {"keyType": "rsa-2048", "profile": "server"};
+        $scope.subCA = {"visible": true, "keyType": "rsa-2048"};
+        $scope.csr = {};
+        $scope.rollover = {"keyType": ""};
+        $scope.crossSign = {};
+        $http.get('ca').success(function(data) {
+            $scope.cas = _(data).reject(function(c) { return c.id == $routeParams.caId; });
+        });
 
         var fetch = function() {
             var certs;
             $http.get('ca/' + $routeParams.caId).success(function(data) {
                 $scope.ca = data;
+                if (data.parentID) {
+                    $http.get('ca/' + data.parentID).success(function(data) {
+                        $scope.parent = data;
+                    });
+                }
             });                          
             $http.get('ca/' + $routeParams.caId + '/cert').success(function(data) {
                 $scope.certs = data;
                 
                 $http.get('ca/' + $routeParams.caId + '/crl').success(function(data) {
-                    revokedCerts = data.serialNumbers;
+                    revokedCerts = data.ids;
                     _($scope.certs).forEach(function(cert) {
-                        cert.isRevoked = _(revokedCerts).contains(cert.serialNumber);
+                        cert.isRevoked = _(revokedCerts).contains(cert.id);
                     });
                 }); 
             });
+            $http.get('ca/' + $routeParams.caId + '/ca').success(function(data) {
+                $scope.subCAs = data;
+
+                $http.get('ca/' + $routeParams.caId + '/crl').success(function(data) {
+                    _($scope.subCAs).forEach(function(sub) {
+                        sub.isRevoked = _(data.ids).contains(sub.id);
+                    });
+                });
+            });
         }
         fetch(); 
 
+        $scope.signCSR = function(csr, cert) {
+            var req = {
+                csr: csr.csr,
+                profile: cert.profile,
+                ttl: cert.ttl,
+                policies: policiesFromForm(cert.policies),
+                extensions: extensionsFromForm(cert.extensions)
+            };
+            $http
+                .post('ca/' + $routeParams.caId + '/csr', req)
+                .success(function(data) {
+                    $location.path('/ca/' + $routeParams.caId + '/cert/' + data.id)
+                });
+        };
+
+        $scope.crossSignCA = function(crossSign) {
+            $http
+                .post('ca/' + $routeParams.caId + '/cross', crossSign)
+                .success(function(data) {
+                    $location.path('/ca/' + data.id)
+                });
+        };
+
+        $scope.rolloverCA = function(rollover) {
+            $http
+                .post('ca/' + $routeParams.caId + '/rollover', rollover)
+                .success(function(data) {
+                    $location.path('/ca/' + data.id)
+                });
+        };
+
+        $scope.generateSubCA = function(subCA) {
+            var req = _.extend({}, subCA, {
+                nameConstraints: nameConstraintsFromForm(subCA.nameConstraints),
+                policies: policiesFromForm(subCA.policies),
+                extensions: extensionsFromForm(subCA.extensions)
+            });
+            $http
+                .post('ca/' + $routeParams.caId + '/ca', req)
+                .success(function(data) {
+                    $location.path('/ca/' + data.id)
+                });
+        };
+
         $scope.generateCert = function(cert) {
+            var req = {
+                subject: subjectFromForm(cert.subject),
+                keyType: cert.keyType,
+                profile: cert.profile,
+                ttl: cert.ttl,
+                dnsNames: splitList(cert.dnsNames),
+                ipAddresses: splitList(cert.ipAddresses),
+                emailAddresses: splitList(cert.emailAddresses),
+                uris: splitList(cert.uris),
+                policies: policiesFromForm(cert.policies),
+                extensions: extensionsFromForm(cert.extensions)
+            };
             $http
-                .post('ca/' + $routeParams.caId + '/cert', cert)
+                .post('ca/' + $routeParams.caId + '/cert', req)
                 .success(function(data) {
-                    $location.path('/ca/' + $routeParams.caId + '/cert/' + data.serialNumber)
+                    $location.path('/ca/' + $routeParams.caId + '/cert/' + data.id)
                 });
         };
 
@@ -103,13 +285,18 @@ microcaApp.filter(
 
 microcaApp.controller(
     'certDetailCtrl', 
-    function certDetailCtrl($scope, $routeParams, $http) {
+    function certDetailCtrl($scope, $routeParams, $http, $location) {
+        $scope.renewal = {"rekey": false, "keyType": ""};
+        $scope.revocation = {"reason": "unspecified"};
+
         var fetch = function() {
             $http.get('ca/' + $routeParams.caId).success(function(data) {
                 $scope.ca = data;
             });
             $http.get('ca/' + $routeParams.caId + '/crl').success(function(data) {
-                $scope.certRevoked = _(data.serialNumbers).contains($routeParams.certId);
+                var revocation = _(data.revocations).find(function(r) { return r.id == $routeParams.certId; });
+                $scope.certRevoked = !!revocation;
+                $scope.revocation = revocation || {"reason": "unspecified"};
             });
             $http.get('ca/' + $routeParams.caId + '/cert/' + $routeParams.certId).success(function(data) {
                 var hostRegexp = /^(\w+\.)+\w+$/;
@@ -123,8 +310,13 @@ microcaApp.controller(
         }
         fetch();
         
-        $scope.revokeCert  = function() {
-            certToRevoke = { serialNumber: $scope.cert.serialNumber };
+        $scope.revokeCert  = function(revocation) {
+            certToRevoke = {
+                id: $scope.cert.id,
+                reason: revocation.reason,
+                invalidityDate: revocation.invalidityDate,
+                revokedBy: revocation.revokedBy
+            };
             $http
                 .post('ca/' + $routeParams.caId + '/crl', certToRevoke)
                 .success(function() {
@@ -132,9 +324,17 @@ microcaApp.controller(
                 });
         };
 
+        $scope.renewCert = function(renewal) {
+            $http
+                .post('ca/' + $routeParams.caId + '/cert/' + $scope.cert.id + '/renew', renewal)
+                .success(function(data) {
+                    $location.path('/ca/' + $routeParams.caId + '/cert/' + data.id);
+                });
+        };
+
         $scope.unrevokeCert  = function() {
             $http
-                .delete('ca/' + $routeParams.caId + '/crl/' + $scope.cert.serialNumber)
+                .delete('ca/' + $routeParams.caId + '/crl/' + $scope.cert.id)
                 .success(function() {
                     fetch();
                 });
@@ -0,0 +1,52 @@
+package liftca
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+)
+
+// parseCSR parses a PKCS#10 certificate signing request, either PEM or DER
+// encoded, and checks its signature.
+func parseCSR(data []byte) (*x509.CertificateRequest, error) {
+	der := data
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+			return nil, fmt.Errorf("Invalid PEM block; should be a CERTIFICATE REQUEST block")
+		}
+		der = block.Bytes
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid CSR signature: %v", err)
+	}
+	return csr, nil
+}
+
+// makeParcelFromCSR has issuers[0] sign the request csr.  The subject and
+// names come from the CSR and are held to the same rules as those of
+// certificates liftCA generates; the profile, validity, policies and
+// extensions come from req.  Any extension requested in the CSR is ignored.
+func makeParcelFromCSR(visible bool, id int64, serial *big.Int, issuers []*Parcel, dp DistributionPoints, csr []byte, req *CertRequest) (*Parcel, error) {
+	request, err := parseCSR(csr)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPublicKey(request.PublicKey); err != nil {
+		return nil, err
+	}
+	signed := *req
+	signed.Subject = request.Subject
+	signed.DNSNames = request.DNSNames
+	signed.IPAddresses = request.IPAddresses
+	signed.EmailAddresses = request.EmailAddresses
+	signed.URIs = request.URIs
+	if err := signed.validate(); err != nil {
+		return nil, err
+	}
+	return signParcel(visible, id, serial, issuers, dp, &signed, request.PublicKey, nil)
+}
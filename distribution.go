@@ -0,0 +1,63 @@
+package liftca
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+)
+
+var oidExtensionFreshestCRL = asn1.ObjectIdentifier{2, 5, 29, 46}
+
+// DistributionPoints are the URLs that certificates issued by a CA embed, so
+// that relying parties can fetch the CA's certificate and check revocation.
+// Empty URLs are left out.
+type DistributionPoints struct {
+	IssuingCertificateURL string
+	CRLURL                string
+	OCSPServer            string
+	// DeltaCRLURL is only set for CAs that publish delta CRLs.
+	DeltaCRLURL string
+}
+
+// apply writes the points into cert as the AuthorityInfoAccess,
+// CRLDistributionPoints and FreshestCRL extensions.
+func (d *DistributionPoints) apply(cert *x509.Certificate) error {
+	if d.IssuingCertificateURL != "" {
+		cert.IssuingCertificateURL = []string{d.IssuingCertificateURL}
+	}
+	if d.OCSPServer != "" {
+		cert.OCSPServer = []string{d.OCSPServer}
+	}
+	if d.CRLURL != "" {
+		cert.CRLDistributionPoints = []string{d.CRLURL}
+	}
+	if d.DeltaCRLURL != "" {
+		ext, err := freshestCRL(d.DeltaCRLURL)
+		if err != nil {
+			return err
+		}
+		cert.ExtraExtensions = append(cert.ExtraExtensions, ext)
+	}
+	return nil
+}
+
+// freshestCRL returns the FreshestCRL extension pointing to the delta CRLs
+// at url (RFC 5280, section 4.2.1.15).  It has the syntax of the
+// CRLDistributionPoints extension, which x509 only offers for the latter.
+func freshestCRL(url string) (pkix.Extension, error) {
+	type distributionPointName struct {
+		FullName []asn1.RawValue `asn1:"optional,tag:0"`
+	}
+	type distributionPoint struct {
+		DistributionPoint distributionPointName `asn1:"optional,tag:0"`
+	}
+	value, err := asn1.Marshal([]distributionPoint{{
+		DistributionPoint: distributionPointName{
+			FullName: []asn1.RawValue{{Tag: 6, Class: asn1.ClassContextSpecific, Bytes: []byte(url)}},
+		},
+	}})
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidExtensionFreshestCRL, Value: value}, nil
+}
@@ -0,0 +1,169 @@
+package liftca
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"net/url"
+)
+
+var (
+	oidExtensionCertificatePolicies = asn1.ObjectIdentifier{2, 5, 29, 32}
+	oidPolicyQualifierCPS           = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 1}
+)
+
+// Policy is a certificate policy, with the URIs of its certification
+// practice statements.
+type Policy struct {
+	OID     asn1.ObjectIdentifier
+	CPSURIs []string
+}
+
+// Extension is an X.509 extension copied as is into certificates, such as
+// the TLS Feature extension of OCSP must-staple (RFC 7633).  Value is the
+// DER encoding of the extension value.
+type Extension struct {
+	OID      asn1.ObjectIdentifier
+	Critical bool
+	Value    []byte
+}
+
+type policyInformation struct {
+	Policy     asn1.ObjectIdentifier
+	Qualifiers []policyQualifierInfo `asn1:"optional,omitempty"`
+}
+
+type policyQualifierInfo struct {
+	PolicyQualifierID asn1.ObjectIdentifier
+	Qualifier         asn1.RawValue
+}
+
+// extraExtensions returns the certificate policies and extensions requested
+// for a certificate, encoded for x509.Certificate.ExtraExtensions.
+// Extensions that liftCA sets itself cannot be requested: x509 lets extra
+// extensions replace them, which would bypass its checks, such as those of
+// the basic constraints or subject alternative names.
+func extraExtensions(policies []Policy, extensions []Extension) ([]pkix.Extension, error) {
+	exts := make([]pkix.Extension, 0, len(extensions)+1)
+	if len(policies) > 0 {
+		infos := make([]policyInformation, len(policies))
+		for i, policy := range policies {
+			if len(policy.OID) < 2 {
+				return nil, fmt.Errorf("invalid policy OID '%v'", policy.OID)
+			}
+			infos[i].Policy = policy.OID
+			for _, uri := range policy.CPSURIs {
+				u, err := url.Parse(uri)
+				if err != nil || !u.IsAbs() {
+					return nil, fmt.Errorf("invalid CPS URI '%v'", uri)
+				}
+				infos[i].Qualifiers = append(infos[i].Qualifiers, policyQualifierInfo{
+					PolicyQualifierID: oidPolicyQualifierCPS,
+					Qualifier:         asn1.RawValue{Tag: asn1.TagIA5String, Bytes: []byte(uri)},
+				})
+			}
+		}
+		value, err := asn1.Marshal(infos)
+		if err != nil {
+			return nil, err
+		}
+		exts = append(exts, pkix.Extension{
+			Id:    oidExtensionCertificatePolicies,
+			Value: value,
+		})
+	}
+	for _, ext := range extensions {
+		if len(ext.OID) < 2 {
+			return nil, fmt.Errorf("invalid extension OID '%v'", ext.OID)
+		}
+		if isLiftCAExtension(ext.OID) {
+			return nil, fmt.Errorf("extension %v is set by liftCA and cannot be requested", ext.OID)
+		}
+		var raw asn1.RawValue
+		if rest, err := asn1.Unmarshal(ext.Value, &raw); err != nil || len(rest) > 0 {
+			return nil, fmt.Errorf("value of extension %v is not a single DER value", ext.OID)
+		}
+		exts = append(exts, pkix.Extension{
+			Id:       ext.OID,
+			Critical: ext.Critical,
+			Value:    ext.Value,
+		})
+	}
+	return exts, nil
+}
+
+// checkExtensions refuses extensions that appear more than once, as a last
+// guard against an extension being both copied and added by liftCA.
+func checkExtensions(exts []pkix.Extension) error {
+	seen := make(map[string]bool)
+	for _, ext := range exts {
+		id := ext.Id.String()
+		if seen[id] {
+			return fmt.Errorf("extension %v appears more than once", id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// liftCAExtensions are the extensions liftCA adds to certificates itself,
+// rather than copying them from a request.
+var liftCAExtensions = []asn1.ObjectIdentifier{
+	{2, 5, 29, 14},              // subject key identifier
+	{2, 5, 29, 15},              // key usage
+	{2, 5, 29, 17},              // subject alternative name
+	{2, 5, 29, 19},              // basic constraints
+	{2, 5, 29, 30},              // name constraints
+	{2, 5, 29, 31},              // CRL distribution points
+	{2, 5, 29, 35},              // authority key identifier
+	{2, 5, 29, 46},              // freshest CRL
+	{1, 3, 6, 1, 5, 5, 7, 1, 1}, // authority information access
+	oidExtensionCertificatePolicies,
+	oidExtensionExtendedKeyUsage,
+	oidExtensionOCSPNoCheck,
+}
+
+// customExtensionsOf returns the extensions of exts that liftCA does not
+// add itself, such as those copied from a request.
+func customExtensionsOf(exts []pkix.Extension) []Extension {
+	custom := make([]Extension, 0)
+	for _, ext := range exts {
+		if !isLiftCAExtension(ext.Id) {
+			custom = append(custom, Extension{OID: ext.Id, Critical: ext.Critical, Value: ext.Value})
+		}
+	}
+	return custom
+}
+
+func isLiftCAExtension(oid asn1.ObjectIdentifier) bool {
+	for _, e := range liftCAExtensions {
+		if oid.Equal(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// policiesOf returns the certificate policies found in exts.
+func policiesOf(exts []pkix.Extension) []Policy {
+	policies := make([]Policy, 0)
+	for _, ext := range exts {
+		if !ext.Id.Equal(oidExtensionCertificatePolicies) {
+			continue
+		}
+		var infos []policyInformation
+		if _, err := asn1.Unmarshal(ext.Value, &infos); err != nil {
+			return policies
+		}
+		for _, info := range infos {
+			policy := Policy{OID: info.Policy, CPSURIs: make([]string, 0)}
+			for _, q := range info.Qualifiers {
+				if q.PolicyQualifierID.Equal(oidPolicyQualifierCPS) && q.Qualifier.Tag == asn1.TagIA5String {
+					policy.CPSURIs = append(policy.CPSURIs, string(q.Qualifier.Bytes))
+				}
+			}
+			policies = append(policies, policy)
+		}
+	}
+	return policies
+}
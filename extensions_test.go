@@ -0,0 +1,117 @@
+package liftca
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+)
+
+// derNull is a valid DER value for extensions whose content does not
+// matter to the test.
+var derNull = []byte{0x05, 0x00}
+
+func TestExtraExtensionsRefusesLiftCAExtensions(t *testing.T) {
+	for _, oid := range liftCAExtensions {
+		_, err := extraExtensions(nil, []Extension{{OID: oid, Value: derNull}})
+		if err == nil {
+			t.Errorf("extension %v accepted", oid)
+		}
+	}
+	// The Freshest CRL extension is added for CAs that publish delta CRLs.
+	if !isLiftCAExtension(asn1.ObjectIdentifier{2, 5, 29, 46}) {
+		t.Error("Freshest CRL extension not reserved")
+	}
+}
+
+func TestExtraExtensions(t *testing.T) {
+	mustStaple := Extension{
+		OID:   asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24},
+		Value: []byte{0x30, 0x03, 0x02, 0x01, 0x05},
+	}
+	tests := []struct {
+		name       string
+		extensions []Extension
+		ok         bool
+	}{
+		{"custom", []Extension{mustStaple}, true},
+		{"short OID", []Extension{{OID: asn1.ObjectIdentifier{1}, Value: derNull}}, false},
+		{"not DER", []Extension{{OID: mustStaple.OID, Value: []byte{0x30, 0x05}}}, false},
+		{"trailing data", []Extension{{OID: mustStaple.OID, Value: append(derNull, 0)}}, false},
+	}
+	for _, test := range tests {
+		exts, err := extraExtensions(nil, test.extensions)
+		if (err == nil) != test.ok {
+			t.Errorf("%v: got error %v", test.name, err)
+			continue
+		}
+		if test.ok && (len(exts) != 1 || !exts[0].Id.Equal(mustStaple.OID)) {
+			t.Errorf("%v: got extensions %v", test.name, exts)
+		}
+	}
+}
+
+func TestExtraExtensionsPolicies(t *testing.T) {
+	oid := asn1.ObjectIdentifier{2, 23, 140, 1, 2, 1}
+	exts, err := extraExtensions([]Policy{{OID: oid, CPSURIs: []string{"http://ca.lab/cps"}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies := policiesOf(exts)
+	if len(policies) != 1 || !policies[0].OID.Equal(oid) || len(policies[0].CPSURIs) != 1 {
+		t.Errorf("got policies %+v", policies)
+	}
+	if _, err := extraExtensions([]Policy{{OID: oid, CPSURIs: []string{"cps"}}}, nil); err == nil {
+		t.Error("relative CPS URI accepted")
+	}
+}
+
+// Requested extensions must not replace those liftCA checks, such as the
+// basic constraints or the subject alternative names.
+func TestRequestedExtensionsCannotBypassChecks(t *testing.T) {
+	s := NewStore()
+	root := addTestCA(t, s, "root", CASettings{})
+	constrained, err := s.AddSubCA(true, root, &CARequest{
+		Subject:         pkix.Name{CommonName: "team"},
+		KeyType:         KeyTypeECDSAP256,
+		NameConstraints: NameConstraints{PermittedDNSDomains: []string{"team.lab"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	isCA, err := asn1.Marshal(struct{ IsCA bool }{true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	san, err := asn1.Marshal([]asn1.RawValue{{Tag: 2, Class: asn1.ClassContextSpecific, Bytes: []byte("evil.lab")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	basicConstraints := Extension{OID: asn1.ObjectIdentifier{2, 5, 29, 19}, Critical: true, Value: isCA}
+	subjectAltName := Extension{OID: asn1.ObjectIdentifier{2, 5, 29, 17}, Value: san}
+
+	if _, err := s.Add(true, root, &CertRequest{DNSNames: []string{"www.lab"}, Extensions: []Extension{basicConstraints}}); err == nil {
+		t.Error("certificate issued with requested basic constraints")
+	}
+	if _, err := s.Add(true, constrained, &CertRequest{DNSNames: []string{"www.team.lab"}, Extensions: []Extension{subjectAltName}}); err == nil {
+		t.Error("certificate issued with requested subject alternative names")
+	}
+	req := &CARequest{Subject: pkix.Name{CommonName: "sub"}, KeyType: KeyTypeECDSAP256, Extensions: []Extension{subjectAltName}}
+	if _, err := s.AddSubCA(true, constrained, req); err == nil {
+		t.Error("sub-CA issued with requested subject alternative names")
+	}
+	if _, err := s.AddCA(true, req); err == nil {
+		t.Error("root CA issued with requested subject alternative names")
+	}
+}
+
+func TestCustomExtensionsOf(t *testing.T) {
+	custom := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}
+	exts := []pkix.Extension{
+		{Id: asn1.ObjectIdentifier{2, 5, 29, 19}, Value: derNull},
+		{Id: custom, Critical: true, Value: derNull},
+	}
+	got := customExtensionsOf(exts)
+	if len(got) != 1 || !got[0].OID.Equal(custom) || !got[0].Critical {
+		t.Errorf("got custom extensions %+v", got)
+	}
+}
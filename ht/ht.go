@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
 	"strconv"
 	"time"
@@ -21,6 +23,7 @@ const (
 	replyTypeRedirect
 	replyTypeReader
 	replyTypeNoContent
+	replyTypeConflict
 )
 
 type Request struct {
@@ -74,6 +77,15 @@ func NoContent() *Answer {
 	}
 }
 
+// Conflict answers that the request clashes with the state of the store, as
+// err explains.
+func Conflict(err error) *Answer {
+	return &Answer{
+		replyType: replyTypeConflict,
+		data:      err,
+	}
+}
+
 func NotFound() *Answer {
 	return &Answer{
 		replyType: replyTypeNotFound,
@@ -96,6 +108,36 @@ func (r *Request) BodyAsJSON(to interface{}) error {
 	return nil
 }
 
+func (r *Request) Body() ([]byte, error) {
+	return ioutil.ReadAll(r.httpRequest.Body)
+}
+
+// ContentType returns the media type of the request body, without parameters.
+func (r *Request) ContentType() string {
+	mediaType, _, err := mime.ParseMediaType(r.httpRequest.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// RemoteAddr returns the network address of the client.
+func (r *Request) RemoteAddr() string {
+	return r.httpRequest.RemoteAddr
+}
+
+// Query returns the value of the URL query parameter key, or "" if there is
+// none.
+func (r *Request) Query(key string) string {
+	return r.httpRequest.URL.Query().Get(key)
+}
+
+// Var returns the route variable key, still URL-encoded, or "" if there is
+// none.
+func (r *Request) Var(key string) string {
+	return mux.Vars(r.httpRequest)[key]
+}
+
 func (r *Request) VarInt64(key string) (int64, error) {
 	vars := mux.Vars(r.httpRequest)
 	val, found := vars[key]
@@ -129,6 +171,8 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		replyNotFound(r, sw)
 	case replyTypeNoContent:
 		replyNoContent(w)
+	case replyTypeConflict:
+		replyConflict(reply.data.(error), sw)
 	default:
 		replyError(fmt.Errorf("Incorrect response handling"), sw)
 	}
@@ -147,6 +191,10 @@ func replyNoContent(w http.ResponseWriter) {
 	http.Error(w, "No Content", http.StatusNoContent)
 }
 
+func replyConflict(err error, w http.ResponseWriter) {
+	http.Error(w, err.Error(), http.StatusConflict)
+}
+
 func replyNotFound(r *http.Request, w http.ResponseWriter) {
 	http.NotFound(w, r)
 }
@@ -10,9 +10,11 @@ type myRouter struct {
 	router *mux.Router
 }
 
+// NewRouter returns a router matching the URL-encoded path, so that route
+// variables may hold encoded slashes, as OCSP GET requests do.
 func NewRouter() *myRouter {
 	return &myRouter{
-		router: mux.NewRouter(),
+		router: mux.NewRouter().UseEncodedPath(),
 	}
 }
 
@@ -1,7 +1,8 @@
 package idsource
 
 import (
-	"math/rand"
+	"crypto/rand"
+	"encoding/binary"
 	"sync"
 )
 
@@ -23,13 +24,16 @@ func New(spent []int64) *IDSource {
 	return s
 }
 
-// Int63 returns a random number, but never the same one (it keeps track of numbers it has already given out).
+// Int63 returns a positive random number, but never the same one (it keeps track of numbers it has already given out).
+// The numbers come from crypto/rand, since knowing the ID of an invisible CA is what grants access to it.
 func (s *IDSource) Int63() int64 {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
+	var b [8]byte
 	for {
-		candidate := rand.Int63()
-		if _, found := s.spent[candidate]; found {
+		rand.Read(b[:])
+		candidate := int64(binary.BigEndian.Uint64(b[:]) >> 1)
+		if _, found := s.spent[candidate]; found || candidate == 0 {
 			continue
 		}
 		s.spent[candidate] = nothing{}
@@ -0,0 +1,172 @@
+package liftca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// KeyType identifies the algorithm and parameters of a private key.
+type KeyType string
+
+const (
+	KeyTypeRSA2048   KeyType = "rsa-2048"
+	KeyTypeRSA3072   KeyType = "rsa-3072"
+	KeyTypeRSA4096   KeyType = "rsa-4096"
+	KeyTypeECDSAP256 KeyType = "ecdsa-p256"
+	KeyTypeECDSAP384 KeyType = "ecdsa-p384"
+	KeyTypeEd25519   KeyType = "ed25519"
+)
+
+// DefaultKeyType is used when a request does not ask for a specific key type.
+const DefaultKeyType = KeyTypeRSA2048
+
+var keyGenerators = map[KeyType]func() (crypto.Signer, error){
+	KeyTypeRSA2048:   rsaKeyGenerator(2048),
+	KeyTypeRSA3072:   rsaKeyGenerator(3072),
+	KeyTypeRSA4096:   rsaKeyGenerator(4096),
+	KeyTypeECDSAP256: ecdsaKeyGenerator(elliptic.P256()),
+	KeyTypeECDSAP384: ecdsaKeyGenerator(elliptic.P384()),
+	KeyTypeEd25519:   ed25519KeyGenerator,
+}
+
+// Each key type gets its own barrel, so that slow key types never hold up
+// fast ones.
+var (
+	keyBarrelsMutex sync.Mutex
+	keyBarrels      = make(map[KeyType]*barrel)
+)
+
+// KeyTypes returns the key types that can be generated.
+func KeyTypes() []KeyType {
+	types := make([]KeyType, 0, len(keyGenerators))
+	for t := range keyGenerators {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// StartKeyBarrel makes keys of type t come from a barrel that keeps size keys
+// pre-generated.  Key types without a started barrel are generated on demand.
+func StartKeyBarrel(t KeyType, size int) error {
+	generate, found := keyGenerators[t]
+	if !found {
+		return fmt.Errorf("unsupported key type '%v'", t)
+	}
+	keyBarrelsMutex.Lock()
+	defer keyBarrelsMutex.Unlock()
+	keyBarrels[t] = NewBarrel(size, generate)
+	return nil
+}
+
+func rsaKeyGenerator(bits int) func() (crypto.Signer, error) {
+	return func() (crypto.Signer, error) {
+		return rsa.GenerateKey(rand.Reader, bits)
+	}
+}
+
+func ecdsaKeyGenerator(curve elliptic.Curve) func() (crypto.Signer, error) {
+	return func() (crypto.Signer, error) {
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	}
+}
+
+func ed25519KeyGenerator() (crypto.Signer, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	return key, err
+}
+
+// newKey takes a fresh key of type t from the matching barrel.
+func newKey(t KeyType) (crypto.Signer, error) {
+	if t == "" {
+		t = DefaultKeyType
+	}
+	generate, found := keyGenerators[t]
+	if !found {
+		return nil, fmt.Errorf("unsupported key type '%v'", t)
+	}
+	keyBarrelsMutex.Lock()
+	b, found := keyBarrels[t]
+	if !found {
+		b = NewBarrel(0, generate)
+		keyBarrels[t] = b
+	}
+	keyBarrelsMutex.Unlock()
+	return b.GetKey(), nil
+}
+
+// keyTypeOf returns the KeyType describing pub, or the empty KeyType if it is unknown.
+func keyTypeOf(pub crypto.PublicKey) KeyType {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return KeyType(fmt.Sprintf("rsa-%d", k.N.BitLen()))
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return KeyTypeECDSAP256
+		case elliptic.P384():
+			return KeyTypeECDSAP384
+		}
+	case ed25519.PublicKey:
+		return KeyTypeEd25519
+	}
+	return ""
+}
+
+// checkPublicKey refuses keys generated elsewhere that liftCA would not
+// generate itself: RSA keys under 2048 bits and unsupported algorithms.
+func checkPublicKey(pub crypto.PublicKey) error {
+	if k, ok := pub.(*rsa.PublicKey); ok {
+		if k.N.BitLen() < 2048 {
+			return fmt.Errorf("RSA keys must have at least 2048 bits, not %v", k.N.BitLen())
+		}
+		return nil
+	}
+	if keyTypeOf(pub) == "" {
+		return fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return nil
+}
+
+// signatureAlgorithmFor returns the algorithm used when signing with a key
+// whose public half is pub.  The hash grows with the size of RSA keys, so
+// that it does not weaken the larger ones.
+func signatureAlgorithmFor(pub crypto.PublicKey) x509.SignatureAlgorithm {
+	if k, ok := pub.(*rsa.PublicKey); ok {
+		switch bits := k.N.BitLen(); {
+		case bits >= 4096:
+			return x509.SHA512WithRSA
+		case bits >= 3072:
+			return x509.SHA384WithRSA
+		}
+		return x509.SHA256WithRSA
+	}
+	switch keyTypeOf(pub) {
+	case KeyTypeECDSAP256:
+		return x509.ECDSAWithSHA256
+	case KeyTypeECDSAP384:
+		return x509.ECDSAWithSHA384
+	case KeyTypeEd25519:
+		return x509.PureEd25519
+	}
+	return x509.UnknownSignatureAlgorithm
+}
+
+// caKeyUsageFor returns the key usage bits appropriate for a CA whose key is
+// pub.  Only RSA keys can be used for key encipherment; other algorithms only
+// sign.  The usages of other certificates come from their Profile.
+func caKeyUsageFor(pub crypto.PublicKey) x509.KeyUsage {
+	usage := x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	if _, ok := pub.(*rsa.PublicKey); ok {
+		usage |= x509.KeyUsageKeyEncipherment
+	}
+	return usage
+}
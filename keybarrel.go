@@ -1,22 +1,21 @@
 package liftca
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
+	"crypto"
 )
 
 type barrel struct {
 	workRequest chan bool
-	ready       chan *rsa.PrivateKey
-	keyBits     int
+	ready       chan crypto.Signer
+	generate    func() (crypto.Signer, error)
 }
 
-// NewBarrel returns a barrel of barrelSize size and generates keyBits-sized keys
-func NewBarrel(barrelSize, keyBits int) *barrel {
+// NewBarrel returns a barrel of barrelSize size and fills it with keys made by generate
+func NewBarrel(barrelSize int, generate func() (crypto.Signer, error)) *barrel {
 	b := &barrel{
 		workRequest: make(chan bool, barrelSize),
-		ready:       make(chan *rsa.PrivateKey, barrelSize),
-		keyBits:     keyBits,
+		ready:       make(chan crypto.Signer, barrelSize),
+		generate:    generate,
 	}
 
 	if barrelSize == 0 {
@@ -32,7 +31,7 @@ func NewBarrel(barrelSize, keyBits int) *barrel {
 }
 
 // GetKey returns a key from the barrel
-func (b *barrel) GetKey() *rsa.PrivateKey {
+func (b *barrel) GetKey() crypto.Signer {
 	b.workRequest <- true
 	k := <-b.ready
 	return k
@@ -41,7 +40,7 @@ func (b *barrel) GetKey() *rsa.PrivateKey {
 func processRequests(b *barrel) {
 	for {
 		<-b.workRequest
-		key, err := rsa.GenerateKey(rand.Reader, b.keyBits)
+		key, err := b.generate()
 		if err != nil {
 			panic(err)
 		}
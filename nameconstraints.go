@@ -0,0 +1,155 @@
+package liftca
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// NameConstraints restricts the names that a CA may issue certificates for,
+// following the NameConstraints extension of RFC 5280, section 4.2.1.10.
+// A domain such as 'team.lab' covers itself and its subdomains, while
+// '.team.lab' only covers its subdomains.  An email constraint is either a
+// complete mailbox or a domain.
+type NameConstraints struct {
+	PermittedDNSDomains     []string
+	ExcludedDNSDomains      []string
+	PermittedIPRanges       []*net.IPNet
+	ExcludedIPRanges        []*net.IPNet
+	PermittedEmailAddresses []string
+	ExcludedEmailAddresses  []string
+}
+
+func nameConstraintsOf(cert *x509.Certificate) NameConstraints {
+	return NameConstraints{
+		PermittedDNSDomains:     cert.PermittedDNSDomains,
+		ExcludedDNSDomains:      cert.ExcludedDNSDomains,
+		PermittedIPRanges:       cert.PermittedIPRanges,
+		ExcludedIPRanges:        cert.ExcludedIPRanges,
+		PermittedEmailAddresses: cert.PermittedEmailAddresses,
+		ExcludedEmailAddresses:  cert.ExcludedEmailAddresses,
+	}
+}
+
+func (c *NameConstraints) isEmpty() bool {
+	return len(c.PermittedDNSDomains) == 0 && len(c.ExcludedDNSDomains) == 0 &&
+		len(c.PermittedIPRanges) == 0 && len(c.ExcludedIPRanges) == 0 &&
+		len(c.PermittedEmailAddresses) == 0 && len(c.ExcludedEmailAddresses) == 0
+}
+
+func (c *NameConstraints) validate() error {
+	for _, domain := range append(append([]string(nil), c.PermittedDNSDomains...), c.ExcludedDNSDomains...) {
+		if strings.HasPrefix(domain, "*") || !validDNSName(strings.TrimPrefix(domain, ".")) {
+			return fmt.Errorf("invalid DNS domain constraint '%v'", domain)
+		}
+	}
+	for _, email := range append(append([]string(nil), c.PermittedEmailAddresses...), c.ExcludedEmailAddresses...) {
+		if !validEmailAddress(email) && (strings.HasPrefix(email, "*") || !validDNSName(strings.TrimPrefix(email, "."))) {
+			return fmt.Errorf("invalid email constraint '%v'", email)
+		}
+	}
+	return nil
+}
+
+// apply writes the constraints into cert as a critical extension.
+func (c *NameConstraints) apply(cert *x509.Certificate) {
+	if c.isEmpty() {
+		return
+	}
+	cert.PermittedDNSDomainsCritical = true
+	cert.PermittedDNSDomains = c.PermittedDNSDomains
+	cert.ExcludedDNSDomains = c.ExcludedDNSDomains
+	cert.PermittedIPRanges = c.PermittedIPRanges
+	cert.ExcludedIPRanges = c.ExcludedIPRanges
+	cert.PermittedEmailAddresses = c.PermittedEmailAddresses
+	cert.ExcludedEmailAddresses = c.ExcludedEmailAddresses
+}
+
+// permits checks every name of req against the constraints.  The hosts of
+// URIs are held to the DNS and IP constraints, as relying parties do.
+func (c *NameConstraints) permits(req *CertRequest) error {
+	check := func(kind, name string, permitted, excluded []string, match func(string, string) bool) error {
+		for _, e := range excluded {
+			if match(name, e) {
+				return fmt.Errorf("%v '%v' is excluded by name constraint '%v'", kind, name, e)
+			}
+		}
+		if len(permitted) == 0 {
+			return nil
+		}
+		for _, p := range permitted {
+			if match(name, p) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%v '%v' is not permitted by the name constraints", kind, name)
+	}
+	checkIP := func(ip net.IP) error {
+		for _, e := range c.ExcludedIPRanges {
+			if e.Contains(ip) {
+				return fmt.Errorf("IP address %v is excluded by name constraint %v", ip, e)
+			}
+		}
+		if len(c.PermittedIPRanges) == 0 {
+			return nil
+		}
+		for _, p := range c.PermittedIPRanges {
+			if p.Contains(ip) {
+				return nil
+			}
+		}
+		return fmt.Errorf("IP address %v is not permitted by the name constraints", ip)
+	}
+
+	for _, name := range req.DNSNames {
+		if err := check("DNS name", name, c.PermittedDNSDomains, c.ExcludedDNSDomains, matchDomainConstraint); err != nil {
+			return err
+		}
+	}
+	for _, ip := range req.IPAddresses {
+		if err := checkIP(ip); err != nil {
+			return err
+		}
+	}
+	for _, email := range req.EmailAddresses {
+		if err := check("email address", email, c.PermittedEmailAddresses, c.ExcludedEmailAddresses, matchEmailConstraint); err != nil {
+			return err
+		}
+	}
+	for _, uri := range req.URIs {
+		host := uri.Hostname()
+		if ip := net.ParseIP(host); ip != nil {
+			if err := checkIP(ip); err != nil {
+				return err
+			}
+		} else if host != "" {
+			if err := check("URI host", host, c.PermittedDNSDomains, c.ExcludedDNSDomains, matchDomainConstraint); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func matchDomainConstraint(domain, constraint string) bool {
+	domain = strings.ToLower(domain)
+	constraint = strings.ToLower(constraint)
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(domain, constraint)
+	}
+	return domain == constraint || strings.HasSuffix(domain, "."+constraint)
+}
+
+func matchEmailConstraint(email, constraint string) bool {
+	if strings.Contains(constraint, "@") {
+		return strings.EqualFold(email, constraint)
+	}
+	at := strings.LastIndex(email, "@")
+	host := strings.ToLower(email[at+1:])
+	constraint = strings.ToLower(constraint)
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(host, constraint)
+	}
+	return host == constraint
+}
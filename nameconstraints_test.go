@@ -0,0 +1,93 @@
+package liftca
+
+import (
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestNameConstraintsPermits(t *testing.T) {
+	_, permittedRange, _ := net.ParseCIDR("10.1.0.0/16")
+	_, excludedRange, _ := net.ParseCIDR("10.1.9.0/24")
+	c := NameConstraints{
+		PermittedDNSDomains:     []string{"team.lab"},
+		ExcludedDNSDomains:      []string{"secret.team.lab"},
+		PermittedIPRanges:       []*net.IPNet{permittedRange},
+		ExcludedIPRanges:        []*net.IPNet{excludedRange},
+		PermittedEmailAddresses: []string{"team.lab", "boss@other.lab"},
+	}
+	uri := func(s string) []*url.URL {
+		u, err := url.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []*url.URL{u}
+	}
+	tests := []struct {
+		name string
+		req  CertRequest
+		ok   bool
+	}{
+		{"domain", CertRequest{DNSNames: []string{"team.lab"}}, true},
+		{"subdomain", CertRequest{DNSNames: []string{"WWW.Team.Lab"}}, true},
+		{"other domain", CertRequest{DNSNames: []string{"other.lab"}}, false},
+		{"suffix only", CertRequest{DNSNames: []string{"myteam.lab"}}, false},
+		{"excluded domain", CertRequest{DNSNames: []string{"x.secret.team.lab"}}, false},
+		{"IP address", CertRequest{IPAddresses: []net.IP{net.ParseIP("10.1.2.3")}}, true},
+		{"other IP address", CertRequest{IPAddresses: []net.IP{net.ParseIP("10.2.0.1")}}, false},
+		{"excluded IP address", CertRequest{IPAddresses: []net.IP{net.ParseIP("10.1.9.1")}}, false},
+		{"email address", CertRequest{EmailAddresses: []string{"me@team.lab"}}, true},
+		{"named email address", CertRequest{EmailAddresses: []string{"Boss@other.lab"}}, true},
+		{"other email address", CertRequest{EmailAddresses: []string{"me@other.lab"}}, false},
+		{"URI", CertRequest{URIs: uri("https://www.team.lab/x")}, true},
+		{"other URI", CertRequest{URIs: uri("https://other.lab/x")}, false},
+		{"URI IP address", CertRequest{URIs: uri("https://10.2.0.1/x")}, false},
+		{"one bad name", CertRequest{DNSNames: []string{"www.team.lab", "other.lab"}}, false},
+	}
+	for _, test := range tests {
+		if err := c.permits(&test.req); (err == nil) != test.ok {
+			t.Errorf("%v: got error %v", test.name, err)
+		}
+	}
+}
+
+func TestNameConstraintsValidate(t *testing.T) {
+	tests := []struct {
+		c  NameConstraints
+		ok bool
+	}{
+		{NameConstraints{PermittedDNSDomains: []string{"team.lab", ".team.lab"}}, true},
+		{NameConstraints{PermittedDNSDomains: []string{"*.team.lab"}}, false},
+		{NameConstraints{ExcludedDNSDomains: []string{"bad domain"}}, false},
+		{NameConstraints{PermittedEmailAddresses: []string{"me@team.lab", "team.lab"}}, true},
+		{NameConstraints{ExcludedEmailAddresses: []string{"*.team.lab"}}, false},
+	}
+	for _, test := range tests {
+		if err := test.c.validate(); (err == nil) != test.ok {
+			t.Errorf("%+v: got error %v", test.c, err)
+		}
+	}
+}
+
+// Constraints bind the sub-CAs of a constrained CA as well.
+func TestNameConstraintsInherited(t *testing.T) {
+	s := NewStore()
+	root := addTestCA(t, s, "root", CASettings{})
+	team, err := s.AddSubCA(true, root, &CARequest{
+		Subject:         pkix.Name{CommonName: "team"},
+		KeyType:         KeyTypeECDSAP256,
+		NameConstraints: NameConstraints{PermittedDNSDomains: []string{"team.lab"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := s.AddSubCA(true, team, &CARequest{Subject: pkix.Name{CommonName: "sub"}, KeyType: KeyTypeECDSAP256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyTestChain(t, s, addTestCert(t, s, sub, "www.team.lab"))
+	if _, err := s.Add(true, sub, &CertRequest{DNSNames: []string{"other.lab"}}); err == nil {
+		t.Error("sub-CA issued a certificate outside the constraints of its issuer")
+	}
+}
@@ -0,0 +1,146 @@
+package liftca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// ocspResponseTTL is how long the responses of the OCSP responder are
+	// good for; clients ask again afterwards.
+	ocspResponseTTL = time.Hour
+	// OCSPStaplingTTL is how long exported OCSP responses are good for.
+	// Servers such as nginx staple them as is until they are replaced, so
+	// they must be exported again within that time.
+	OCSPStaplingTTL = 7 * 24 * time.Hour
+	// ocspResponderTTL is the validity period of delegated OCSP responder
+	// certificates.
+	ocspResponderTTL = 30 * 24 * time.Hour
+	// ocspResponderRenewal is how long before it expires a delegated OCSP
+	// responder certificate is replaced.  It exceeds OCSPStaplingTTL, so
+	// that exported responses do not outlive their signer.
+	ocspResponderRenewal = 10 * 24 * time.Hour
+)
+
+// x/crypto/ocsp only signs with PKCS #1 v1.5 padding; CAs set to sign with
+// RSA-PSS sign their OCSP responses with the same hash and that padding.
+var ocspRSAPSSFallbacks = map[x509.SignatureAlgorithm]x509.SignatureAlgorithm{
+	x509.SHA256WithRSAPSS: x509.SHA256WithRSA,
+	x509.SHA384WithRSAPSS: x509.SHA384WithRSA,
+	x509.SHA512WithRSAPSS: x509.SHA512WithRSA,
+}
+
+// isOCSPIssuerOf reports whether the OCSP request req is about a
+// certificate issued by the CA ca, according to the hashes of its name and
+// key (RFC 6960, section 4.1.1).
+func (ca *Parcel) isOCSPIssuerOf(req *ocsp.Request) bool {
+	if !req.HashAlgorithm.Available() {
+		return false
+	}
+	var spki struct {
+		Algorithm        pkix.AlgorithmIdentifier
+		SubjectPublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(ca.Certificate.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return false
+	}
+	h := req.HashAlgorithm.New()
+	h.Write(ca.Certificate.RawSubject)
+	if !bytes.Equal(h.Sum(nil), req.IssuerNameHash) {
+		return false
+	}
+	h.Reset()
+	h.Write(spki.SubjectPublicKey.RightAlign())
+	return bytes.Equal(h.Sum(nil), req.IssuerKeyHash)
+}
+
+// ocspResponderKeyType returns the key type of the delegated OCSP
+// responders of the CA ca: that of the CA, unless OCSP responses cannot be
+// signed with it.
+func ocspResponderKeyType(ca *Parcel) KeyType {
+	t := keyTypeOf(ca.Certificate.PublicKey)
+	if _, found := keyGenerators[t]; !found || t == KeyTypeEd25519 {
+		return KeyTypeECDSAP256
+	}
+	return t
+}
+
+// makeOCSPResponderParcel has issuers[0] issue a delegated OCSP responder
+// certificate (RFC 6960, section 4.2.2.2), named after the CA.  The other
+// issuers are those of issuers[0], up to the root; dp are the distribution
+// points of issuers[0].
+func makeOCSPResponderParcel(id int64, serial *big.Int, issuers []*Parcel, dp DistributionPoints) (*Parcel, error) {
+	ca := issuers[0]
+	key, err := newKey(ocspResponderKeyType(ca))
+	if err != nil {
+		return nil, err
+	}
+	subject := ca.Certificate.Subject
+	subject.CommonName = "OCSP Responder"
+	if ca.Certificate.Subject.CommonName != "" {
+		subject.CommonName = ca.Certificate.Subject.CommonName + " OCSP Responder"
+	}
+	notAfter := time.Now().Add(ocspResponderTTL)
+	if notAfter.After(ca.Certificate.NotAfter) {
+		notAfter = ca.Certificate.NotAfter
+	}
+	req := &CertRequest{
+		Subject:  subject,
+		Profile:  ProfileOCSPSigning,
+		Validity: Validity{NotAfter: notAfter},
+	}
+	return signParcel(ca.Visible, id, serial, issuers, dp, req, key.Public(), key)
+}
+
+// ocspResponderExpiring reports whether the delegated OCSP responder
+// certificate of the CA ca should be replaced by a new one.
+func ocspResponderExpiring(ca, responder *Parcel) bool {
+	notAfter := responder.Certificate.NotAfter
+	return notAfter.Before(time.Now().Add(ocspResponderRenewal)) && notAfter.Before(ca.Certificate.NotAfter)
+}
+
+// signOCSPResponse has responder, either the CA ca or its delegated
+// responder, sign the OCSP response described by template.
+func signOCSPResponse(ca, responder *Parcel, template ocsp.Response) ([]byte, error) {
+	signer := responder.Signer()
+	if signer == nil {
+		return nil, fmt.Errorf("OCSP responder %v has no private key", responder.ID)
+	}
+	sigAlg, err := responder.x509SignatureAlgorithm()
+	if err != nil {
+		return nil, err
+	}
+	if fallback, found := ocspRSAPSSFallbacks[sigAlg]; found {
+		sigAlg = fallback
+	}
+	template.SignatureAlgorithm = sigAlg
+	if responder != ca {
+		template.Certificate = responder.Certificate
+	}
+	if template.NextUpdate.After(responder.Certificate.NotAfter) {
+		template.NextUpdate = responder.Certificate.NotAfter
+	}
+	return ocsp.CreateResponse(ca.Certificate, responder.Certificate, template, signer)
+}
+
+// ocspTemplate returns the unsigned OCSP response about the certificate
+// serial, good for ttl from now.  The response uses hash to identify the
+// issuer, as in the request.
+func ocspTemplate(serial *big.Int, hash crypto.Hash, ttl time.Duration) ocsp.Response {
+	now := time.Now()
+	return ocsp.Response{
+		Status:       ocsp.Unknown,
+		SerialNumber: serial,
+		IssuerHash:   hash,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(ttl),
+	}
+}
@@ -0,0 +1,152 @@
+package liftca
+
+import (
+	"crypto"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestOCSPResponse(t *testing.T) {
+	tests := []struct {
+		name      string
+		keyType   KeyType
+		delegated bool
+	}{
+		{"ECDSA", KeyTypeECDSAP256, false},
+		{"ECDSA delegated", KeyTypeECDSAP256, true},
+		// OCSP responses cannot be signed with Ed25519, so those CAs
+		// always delegate.
+		{"Ed25519", KeyTypeEd25519, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewStore()
+			caID, err := s.AddCA(true, &CARequest{
+				Subject:  pkix.Name{CommonName: "root"},
+				KeyType:  test.keyType,
+				Settings: CASettings{DelegatedOCSP: test.delegated},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ca := getTestParcel(t, s, caID)
+			id := addTestCert(t, s, caID, "www.lab")
+			leaf := getTestParcel(t, s, id)
+
+			for _, hash := range []crypto.Hash{crypto.SHA1, crypto.SHA256} {
+				req, err := ocsp.CreateRequest(leaf.Certificate, ca.Certificate, &ocsp.RequestOptions{Hash: hash})
+				if err != nil {
+					t.Fatal(err)
+				}
+				der, err := s.OCSPResponse(caID, req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r, err := ocsp.ParseResponseForCert(der, leaf.Certificate, ca.Certificate)
+				if err != nil {
+					t.Fatalf("%v: %v", hash, err)
+				}
+				if r.Status != ocsp.Good {
+					t.Errorf("%v: status is %v, want good", hash, r.Status)
+				}
+				if wantDelegated := test.delegated || test.keyType == KeyTypeEd25519; (r.Certificate != nil) != wantDelegated {
+					t.Errorf("%v: response has responder certificate %v", hash, r.Certificate != nil)
+				}
+			}
+
+			if err := s.Revoke(id, Revocation{Reason: ReasonKeyCompromise}); err != nil {
+				t.Fatal(err)
+			}
+			der, err := s.OCSPStaple(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := ocsp.ParseResponseForCert(der, leaf.Certificate, ca.Certificate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Status != ocsp.Revoked || r.RevocationReason != int(ReasonKeyCompromise) {
+				t.Errorf("status is %v with reason %v, want revoked for keyCompromise", r.Status, r.RevocationReason)
+			}
+		})
+	}
+}
+
+func TestOCSPResponseRefusesOtherIssuers(t *testing.T) {
+	s := NewStore()
+	a := addTestCA(t, s, "a", CASettings{})
+	b := addTestCA(t, s, "b", CASettings{})
+	leaf := getTestParcel(t, s, addTestCert(t, s, b, "www.lab"))
+
+	req, err := ocsp.CreateRequest(leaf.Certificate, getTestParcel(t, s, b).Certificate, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := s.OCSPResponse(a, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ocsp.ParseResponse(der, nil); err != (ocsp.ResponseError{Status: ocsp.Unauthorized}) {
+		t.Errorf("request for another CA answered with %v", err)
+	}
+
+	der, err = s.OCSPResponse(a, []byte("junk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ocsp.ParseResponse(der, nil); err != (ocsp.ResponseError{Status: ocsp.Malformed}) {
+		t.Errorf("malformed request answered with %v", err)
+	}
+}
+
+// Delegated responders are not certificates of their CA: they are neither
+// listed, renewed nor revoked like those, and each replaces the last.
+func TestDelegatedOCSPResponderIsNotACertificate(t *testing.T) {
+	s := NewStore()
+	caID := addTestCA(t, s, "root", CASettings{DelegatedOCSP: true})
+	ca := getTestParcel(t, s, caID)
+	id := addTestCert(t, s, caID, "www.lab")
+
+	responder, err := s.ocspResponder(ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if children, _ := s.GetChildren(caID); len(children) != 1 || children[0] != id {
+		t.Errorf("certificates of the CA are %v, want [%v]", children, id)
+	}
+	if _, found := s.GetParent(responder.ID); found {
+		t.Error("responder has a parent CA")
+	}
+	if _, err := s.Renew(responder.ID, &RenewRequest{}); err == nil {
+		t.Error("responder renewed")
+	}
+
+	// Make the responder about to expire, so that it is replaced.
+	responder.Certificate.NotAfter = time.Now().Add(time.Hour)
+	next, err := s.ocspResponder(ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.ID == responder.ID {
+		t.Fatal("expiring responder not replaced")
+	}
+	if _, found := s.Get(responder.ID); found {
+		t.Error("replaced responder still in the store")
+	}
+	if again, _ := s.ocspResponder(ca); again.ID != next.ID {
+		t.Error("responder replaced again")
+	}
+}
+
+func TestOCSPStapleWithoutIssuer(t *testing.T) {
+	s := NewStore()
+	ca := addTestCA(t, s, "root", CASettings{})
+	id := addTestCert(t, s, ca, "www.lab")
+	s.withLocked(func() { delete(s.m, ca) })
+	if _, err := s.OCSPStaple(id); err == nil {
+		t.Error("staple made without the issuing CA")
+	}
+}
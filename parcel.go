@@ -2,31 +2,94 @@ package liftca
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/sha1"
 	"crypto/x509"
 	"crypto/x509/pkix"
-	"encoding/asn1"
 	"encoding/pem"
 	"fmt"
 	"io"
 	"math/big"
-	"net"
 	"time"
 )
 
-var keyBarrel = NewBarrel(8, 1024)
-
 type Parcel struct {
-	Visible             bool
+	// ID identifies the parcel in its store.  It is unrelated to the serial
+	// number of the certificate, except in certificates issued by earlier
+	// versions of liftCA.
+	ID      int64
+	Visible bool
+	// Certificate is parsed from DERCertificateBytes.  It is not persisted,
+	// since parsed certificates cannot be gob encoded; see forStorage.
 	Certificate         *x509.Certificate
-	PrivateKey          *rsa.PrivateKey
 	DERCertificateBytes []byte
+	// PKCS8PrivateKey holds the private key, whatever its algorithm.
+	PKCS8PrivateKey []byte
+	// PrivateKey is only set in parcels loaded from stores written before
+	// keys were kept in PKCS#8 form; see upgrade.
+	PrivateKey *rsa.PrivateKey
+	// Settings is only set on CAs.
+	Settings *CASettings
 }
 
-func (p *Parcel) SerialNumber() int64 {
-	return p.Certificate.SerialNumber.Int64()
+// newParcel returns a parcel for the DER certificate raw and its private
+// key, if known.
+func newParcel(visible bool, id int64, raw []byte, key crypto.Signer) (*Parcel, error) {
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		return nil, err
+	}
+	p := &Parcel{
+		ID:                  id,
+		Visible:             visible,
+		Certificate:         cert,
+		DERCertificateBytes: raw,
+	}
+	if key != nil {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		p.PKCS8PrivateKey = der
+	}
+	return p, nil
+}
+
+// upgrade prepares a parcel loaded from a store under id: it parses the
+// certificate, and moves a legacy RSA private key into PKCS8PrivateKey.
+// Older stores also hold a certificate template, which is kept if the
+// certificate cannot be parsed.
+func (p *Parcel) upgrade(id int64) error {
+	p.ID = id
+	if cert, err := x509.ParseCertificate(p.DERCertificateBytes); err == nil {
+		p.Certificate = cert
+	} else if p.Certificate == nil {
+		return err
+	}
+	if p.PrivateKey == nil {
+		return nil
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(p.PrivateKey)
+	if err != nil {
+		return err
+	}
+	p.PKCS8PrivateKey = der
+	p.PrivateKey = nil
+	return nil
+}
+
+// forStorage returns a copy of the parcel without its parsed certificate.
+func (p *Parcel) forStorage() *Parcel {
+	c := *p
+	c.Certificate = nil
+	return &c
+}
+
+// SerialNumber returns the serial number of the certificate.
+func (p *Parcel) SerialNumber() *big.Int {
+	return p.Certificate.SerialNumber
 }
 
 func (p *Parcel) SubjectKeyID() string {
@@ -37,215 +100,522 @@ func (p *Parcel) AuthorityKeyID() string {
 	return fmt.Sprintf("% x", p.Certificate.AuthorityKeyId)
 }
 
-func (p *Parcel) PublicKey() *rsa.PublicKey {
-	return &p.PrivateKey.PublicKey
+// Signer returns the private key of the parcel, or nil if it has none.
+func (p *Parcel) Signer() crypto.Signer {
+	if len(p.PKCS8PrivateKey) == 0 {
+		return nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(p.PKCS8PrivateKey)
+	if err != nil {
+		return nil
+	}
+	signer, _ := key.(crypto.Signer)
+	return signer
+}
+
+func (p *Parcel) HasPrivateKey() bool {
+	return len(p.PKCS8PrivateKey) > 0
+}
+
+func (p *Parcel) PublicKey() crypto.PublicKey {
+	if signer := p.Signer(); signer != nil {
+		return signer.Public()
+	}
+	return p.Certificate.PublicKey
+}
+
+func (p *Parcel) KeyType() KeyType {
+	return keyTypeOf(p.PublicKey())
+}
+
+// Profile returns the profile matching the certificate's extended key
+// usages, or the empty Profile if none does.
+func (p *Parcel) Profile() Profile {
+	return profileOf(p.Certificate)
+}
+
+// NameConstraints returns the name constraints of the CA's certificate.
+func (p *Parcel) NameConstraints() NameConstraints {
+	return nameConstraintsOf(p.Certificate)
+}
+
+// ExtraNameConstraints returns the name constraints that liftCA enforces on
+// top of those of the CA's certificate.
+func (p *Parcel) ExtraNameConstraints() NameConstraints {
+	if p.Settings == nil {
+		return NameConstraints{}
+	}
+	return p.Settings.NameConstraints
+}
+
+// Policies returns the certificate policies of the parcel's certificate.
+func (p *Parcel) Policies() []Policy {
+	return policiesOf(p.Certificate.Extensions)
+}
+
+// DefaultCertTTL returns the validity period of certificates issued by this
+// CA when they do not request one.
+func (p *Parcel) DefaultCertTTL() time.Duration {
+	if p.Settings == nil || p.Settings.DefaultTTL == 0 {
+		return DefaultCertTTL
+	}
+	return p.Settings.DefaultTTL
+}
+
+// CRLTTL returns how long the CRLs of this CA are good for, that is the
+// interval between their thisUpdate and nextUpdate.
+func (p *Parcel) CRLTTL() time.Duration {
+	if ttl := p.settings().CRLTTL; ttl != 0 {
+		return ttl
+	}
+	return DefaultCRLTTL
+}
+
+// DeltaCRLTTL returns how long the delta CRLs of this CA are good for, or
+// zero if it publishes none.
+func (p *Parcel) DeltaCRLTTL() time.Duration {
+	return p.settings().DeltaCRLTTL
+}
+
+// settings returns the settings of the CA, or the defaults if it has none.
+func (p *Parcel) settings() CASettings {
+	if p.Settings == nil {
+		return CASettings{}
+	}
+	return *p.Settings
+}
+
+// SignatureAlgorithm returns the signature algorithm the CA was configured
+// with; empty means the default for its key.
+func (p *Parcel) SignatureAlgorithm() SignatureAlgorithm {
+	return p.settings().SignatureAlgorithm
+}
+
+// KeyIDMethod returns the method the CA computes the key identifiers of the
+// certificates it issues with; empty means KeyIDSHA1.
+func (p *Parcel) KeyIDMethod() KeyIDMethod {
+	return p.settings().KeyIDMethod
+}
+
+// SerialPolicy returns how the CA picks the serial numbers of the
+// certificates it issues.
+func (p *Parcel) SerialPolicy() SerialPolicy {
+	if policy := p.settings().SerialPolicy; policy != "" {
+		return policy
+	}
+	return SerialRandom
+}
+
+// DelegatedOCSP reports whether the OCSP responses about the certificates
+// of the CA are signed by a responder certificate it issued for the purpose,
+// rather than by the CA itself.  CAs with Ed25519 keys always delegate, as
+// OCSP responses cannot be signed with them.
+func (p *Parcel) DelegatedOCSP() bool {
+	return p.settings().DelegatedOCSP || keyTypeOf(p.PublicKey()) == KeyTypeEd25519
+}
+
+// x509SignatureAlgorithm returns the algorithm the CA signs with.
+func (p *Parcel) x509SignatureAlgorithm() (x509.SignatureAlgorithm, error) {
+	return x509SignatureAlgorithm(p.PublicKey(), p.SignatureAlgorithm())
+}
+
+// IsSelfSigned reports whether the certificate is its own issuer.
+func (p *Parcel) IsSelfSigned() bool {
+	cert := p.Certificate
+	return bytes.Equal(cert.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(cert) == nil
 }
 
 func (p *Parcel) Host() string {
-	if len(p.Certificate.IPAddresses) > 0 {
-		return p.Certificate.IPAddresses[0].String()
-	} else {
+	switch {
+	case p.Certificate.Subject.CommonName != "":
 		return p.Certificate.Subject.CommonName
+	case len(p.Certificate.DNSNames) > 0:
+		return p.Certificate.DNSNames[0]
+	case len(p.Certificate.IPAddresses) > 0:
+		return p.Certificate.IPAddresses[0].String()
+	case len(p.Certificate.EmailAddresses) > 0:
+		return p.Certificate.EmailAddresses[0]
+	case len(p.Certificate.URIs) > 0:
+		return p.Certificate.URIs[0].String()
+	}
+	return ""
+}
+
+// importCAFromPEM returns a parcel for an existing CA certificate and its
+// private key, both PEM encoded; see parsePEMPrivateKey for the supported
+// key formats.
+func importCAFromPEM(visible bool, id int64, certificate, privKey, keyPassword []byte, settings CASettings) (*Parcel, error) {
+	certs, err := parsePEMCertificates(certificate)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) != 1 {
+		return nil, fmt.Errorf("Expected exactly one certificate, found %v", len(certs))
+	}
+	key, err := parsePEMPrivateKey(privKey, keyPassword)
+	if err != nil {
+		return nil, err
+	}
+	return importCA(visible, id, certs[0], key, settings)
+}
+
+// importCAFromPKCS12 returns a parcel for the CA certificate and private key
+// of a PKCS#12 bundle, along with the issuers of the CA found in the bundle,
+// from its own issuer up.
+func importCAFromPKCS12(visible bool, id int64, pfx, password []byte, settings CASettings) (*Parcel, []*x509.Certificate, error) {
+	key, cert, others, err := parsePKCS12(pfx, password)
+	if err != nil {
+		return nil, nil, err
+	}
+	p, err := importCA(visible, id, cert, key, settings)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, issuersOf(cert, others), nil
+}
+
+// importCA returns a parcel for an existing CA certificate and its private
+// key.  The certificate is kept as it is.
+func importCA(visible bool, id int64, cert *x509.Certificate, key crypto.Signer, settings CASettings) (*Parcel, error) {
+	if err := settings.validate(cert.PublicKey); err != nil {
+		return nil, err
+	}
+	if !cert.BasicConstraintsValid || !cert.IsCA {
+		return nil, fmt.Errorf("Certificate is not a CA certificate")
+	}
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.PublicKey) {
+		return nil, fmt.Errorf("Private key does not match the certificate")
+	}
+	p, err := newParcel(visible, id, cert.Raw, key)
+	if err != nil {
+		return nil, err
+	}
+	p.Settings = &settings
+	return p, nil
+}
+
+// parsePEMCertificates returns the certificates of the CERTIFICATE blocks in
+// data, in order.
+func parsePEMCertificates(data []byte) ([]*x509.Certificate, error) {
+	certs := make([]*x509.Certificate, 0)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("Invalid PEM block; should be a CERTIFICATE block")
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(bytes.TrimSpace(data)) > 0 && len(certs) == 0 {
+		return nil, fmt.Errorf("Invalid PEM block")
 	}
+	return certs, nil
 }
 
-func importCAFromPEM(visible bool, serial int64, certificate, privKey, keyPassword []byte) (p *Parcel, err error) {
-	certBlock, _ := pem.Decode(certificate)
-	if certBlock == nil {
-		err = fmt.Errorf("Invalid PEM block")
+// issuersOf picks the issuers of cert among certs and returns them in order,
+// from the issuer of cert up to a self-signed root if there is one.
+func issuersOf(cert *x509.Certificate, certs []*x509.Certificate) []*x509.Certificate {
+	issuers := make([]*x509.Certificate, 0)
+	remaining := append([]*x509.Certificate(nil), certs...)
+	for !bytes.Equal(cert.RawSubject, cert.RawIssuer) {
+		found := false
+		for i, c := range remaining {
+			if c.IsCA && cert.CheckSignatureFrom(c) == nil {
+				issuers = append(issuers, c)
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				cert, found = c, true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	return issuers
+}
+
+// isIssuerOf reports whether the CA p issued cert: its subject and subject
+// key identifier match the issuer and authority key identifier of cert, and
+// its key verifies the signature of cert.
+func (p *Parcel) isIssuerOf(cert *x509.Certificate) bool {
+	if !p.Certificate.IsCA || !bytes.Equal(p.Certificate.RawSubject, cert.RawIssuer) {
+		return false
+	}
+	if len(cert.AuthorityKeyId) > 0 && len(p.Certificate.SubjectKeyId) > 0 && !bytes.Equal(cert.AuthorityKeyId, p.Certificate.SubjectKeyId) {
+		return false
+	}
+	return cert.CheckSignatureFrom(p.Certificate) == nil
+}
+
+// checkChain verifies that each certificate of chain is a CA that issued
+// the one before it, starting with cert.
+func checkChain(cert *x509.Certificate, chain []*x509.Certificate) error {
+	for _, parent := range chain {
+		if !parent.BasicConstraintsValid || !parent.IsCA {
+			return fmt.Errorf("Chain certificate '%v' is not a CA certificate", parent.Subject)
+		}
+		if err := cert.CheckSignatureFrom(parent); err != nil {
+			return fmt.Errorf("Certificate '%v' was not issued by '%v': %v", cert.Subject, parent.Subject, err)
+		}
+		cert = parent
+	}
+	return nil
+}
+
+// makeCAParcel creates a CA signed by issuer, or a self-signed root CA if
+// issuer is nil.  The distribution points dp are those of issuer.
+func makeCAParcel(visible bool, id int64, serial *big.Int, req *CARequest, issuer *Parcel, dp DistributionPoints) (p *Parcel, err error) {
+	var issuerCert *x509.Certificate
+	var issuerKey crypto.Signer
+	if issuer != nil {
+		issuerCert = issuer.Certificate
+		issuerKey = issuer.Signer()
+		if issuerKey == nil {
+			err = fmt.Errorf("CA %v has no private key", issuer.ID)
+			return
+		}
+	}
+	err = validateSubject(req.Subject)
+	if err != nil {
 		return
 	}
-	if certBlock.Type != "CERTIFICATE" {
-		err = fmt.Errorf("Invalid PEM block; should be a CERTIFICATE block")
+	err = req.NameConstraints.validate()
+	if err != nil {
 		return
 	}
-
-	keyBlock, _ := pem.Decode(privKey)
-	if keyBlock == nil {
-		err = fmt.Errorf("Invalid PEM block")
+	maxPathLen, err := req.maxPathLen(issuerCert)
+	if err != nil {
 		return
 	}
-	if keyBlock.Type != "RSA PRIVATE KEY" {
-		err = fmt.Errorf("Invalid PEM block; should be a RSA PRIVATE KEY block")
+	notBefore, notAfter, err := req.Validity.period(DefaultCATTL, issuerCert)
+	if err != nil {
 		return
 	}
-	keyBlockBytes := keyBlock.Bytes
-	if len(keyPassword) > 0 {
-		keyBlockBytes, err = x509.DecryptPEMBlock(keyBlock, keyPassword)
-		if err != nil {
+	extra, err := extraExtensions(req.Policies, req.Extensions)
+	if err != nil {
+		return
+	}
+	key, err := newKey(req.KeyType)
+	if err != nil {
+		return
+	}
+	err = req.Settings.validate(key.Public())
+	if err != nil {
+		return
+	}
+	// A root CA signs its own certificate, and so follows its own settings.
+	signing := req.Settings
+	if issuer != nil {
+		signing = issuer.settings()
+	}
+	cert := makeCertTemplate(true, req.Subject, serial, key.Public(), extra)
+	cert.NotBefore = notBefore
+	cert.NotAfter = notAfter
+	cert.MaxPathLen = maxPathLen
+	cert.MaxPathLenZero = maxPathLen == 0
+	req.NameConstraints.apply(cert)
+	if issuer != nil {
+		if err = dp.apply(cert); err != nil {
 			return
 		}
 	}
 
-	certs, err := x509.ParseCertificates(certBlock.Bytes)
+	h, err := subjectKeyID(key.Public(), signing.KeyIDMethod)
 	if err != nil {
 		return
 	}
-	if len(certs) != 1 {
-		err = fmt.Errorf("Found too many certificates in PEM block")
+	cert.SubjectKeyId = h
+	if issuer == nil {
+		issuerCert = cert
+		issuerKey = key
+	}
+	cert.AuthorityKeyId = issuerCert.SubjectKeyId
+	cert.SignatureAlgorithm, err = x509SignatureAlgorithm(issuerKey.Public(), signing.SignatureAlgorithm)
+	if err != nil {
 		return
 	}
 
-	cert := &x509.Certificate{
-		Subject:               certs[0].Subject,
-		IsCA:                  true,
-		MaxPathLen:            certs[0].MaxPathLen,
-		BasicConstraintsValid: true,
-		SerialNumber:          big.NewInt(serial),
-		SubjectKeyId:          certs[0].SubjectKeyId,
-		AuthorityKeyId:        certs[0].AuthorityKeyId,
-		NotBefore:             time.Date(2010, 12, 31, 23, 59, 59, 0, time.UTC),
-		NotAfter:              time.Date(2049, 12, 31, 23, 59, 59, 0, time.UTC),
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	err = checkExtensions(cert.ExtraExtensions)
+	if err != nil {
+		return
 	}
 
-	key, err := x509.ParsePKCS1PrivateKey(keyBlockBytes)
+	raw, err := x509.CreateCertificate(rand.Reader, cert, issuerCert, key.Public(), issuerKey)
 	if err != nil {
 		return
 	}
 
-	p = &Parcel{
-		Visible:             visible,
-		Certificate:         cert,
-		PrivateKey:          key,
-		DERCertificateBytes: certs[0].Raw,
+	p, err = newParcel(visible, id, raw, key)
+	if err != nil {
+		return
 	}
-
+	settings := req.Settings
+	p.Settings = &settings
 	return
 }
 
-func makeCAParcel(visible bool, serial int64, name string) (p *Parcel, err error) {
-	ser := big.NewInt(serial)
-	cert := makeCertTemplate(true, "", name, ser)
-	key := keyBarrel.GetKey()
-
-	hasher := sha1.New()
-	bytes, err := asn1.Marshal(key.PublicKey)
+// makeParcel has issuers[0] issue a certificate and key as described by req.
+// The other issuers are those of issuers[0], up to the root; dp are the
+// distribution points of issuers[0].
+func makeParcel(visible bool, id int64, serial *big.Int, issuers []*Parcel, dp DistributionPoints, req *CertRequest) (p *Parcel, err error) {
+	err = req.validate()
 	if err != nil {
 		return
 	}
-	hasher.Write(bytes)
-	h := hasher.Sum(nil)
-	cert.SubjectKeyId = h
-	cert.AuthorityKeyId = h
-
-	raw, err := x509.CreateCertificate(rand.Reader, cert, cert, &key.PublicKey, key)
+	key, err := newKey(req.KeyType)
 	if err != nil {
 		return
 	}
-
-	p = &Parcel{
-		Visible:             visible,
-		Certificate:         cert,
-		PrivateKey:          key,
-		DERCertificateBytes: raw,
-	}
-	return
+	return signParcel(visible, id, serial, issuers, dp, req, key.Public(), key)
 }
 
-func makeParcel(visible bool, serial int64, ca *Parcel, host string) (p *Parcel, err error) {
-	ser := big.NewInt(serial)
-	cert := makeCertTemplate(
-		false,
-		host,
-		ca.Certificate.Subject.CommonName,
-		ser)
-	key := keyBarrel.GetKey()
-	hasher := sha1.New()
-	bytes, err := asn1.Marshal(key.PublicKey)
-	hasher.Write(bytes)
-	h := hasher.Sum(nil)
+// signParcel has issuers[0] issue a certificate for pub as described by req,
+// which must already be valid.  The names of req must satisfy the name
+// constraints of every issuer.  The private key is optional: parcels for
+// keys held elsewhere have none.
+func signParcel(visible bool, id int64, serial *big.Int, issuers []*Parcel, dp DistributionPoints, req *CertRequest, pub crypto.PublicKey, key crypto.Signer) (p *Parcel, err error) {
+	ca := issuers[0]
+	caKey := ca.Signer()
+	if caKey == nil {
+		err = fmt.Errorf("CA %v has no private key", ca.ID)
+		return
+	}
+	for _, issuer := range issuers {
+		for _, constraints := range []NameConstraints{issuer.NameConstraints(), issuer.ExtraNameConstraints()} {
+			err = constraints.permits(req)
+			if err != nil {
+				return
+			}
+		}
+	}
+	notBefore, notAfter, err := req.Validity.period(ca.DefaultCertTTL(), ca.Certificate)
+	if err != nil {
+		return
+	}
+	extra, err := extraExtensions(req.Policies, req.Extensions)
+	if err != nil {
+		return
+	}
+	cert := makeCertTemplate(false, req.subject(), serial, pub, extra)
+	cert.NotBefore = notBefore
+	cert.NotAfter = notAfter
+	err = req.Profile.apply(cert, pub)
+	if err != nil {
+		return
+	}
+	cert.DNSNames = req.DNSNames
+	cert.IPAddresses = req.IPAddresses
+	cert.EmailAddresses = req.EmailAddresses
+	cert.URIs = req.URIs
+	err = dp.apply(cert)
+	if err != nil {
+		return
+	}
+	h, err := subjectKeyID(pub, ca.KeyIDMethod())
+	if err != nil {
+		return
+	}
 	cert.SubjectKeyId = h
 	cert.AuthorityKeyId = ca.Certificate.SubjectKeyId
+	cert.SignatureAlgorithm, err = ca.x509SignatureAlgorithm()
+	if err != nil {
+		return
+	}
 
-	raw, err := x509.CreateCertificate(rand.Reader, cert, ca.Certificate, &key.PublicKey, ca.PrivateKey)
+	err = checkExtensions(cert.ExtraExtensions)
 	if err != nil {
 		return
 	}
 
-	p = &Parcel{
-		Visible:             visible,
-		Certificate:         cert,
-		PrivateKey:          key,
-		DERCertificateBytes: raw,
+	raw, err := x509.CreateCertificate(rand.Reader, cert, ca.Certificate, pub, caKey)
+	if err != nil {
+		return
 	}
-	return
+
+	return newParcel(visible, id, raw, key)
 }
 
-func makeCertTemplate(isCA bool, host, name string, serial *big.Int) *x509.Certificate {
+// makeCertTemplate returns a template for a certificate, carrying the extra
+// extensions as is.  CAs get no extended key usage, so that they can issue
+// certificates of any profile.
+func makeCertTemplate(isCA bool, subject pkix.Name, serial *big.Int, pub crypto.PublicKey, extra []pkix.Extension) *x509.Certificate {
 	cert := &x509.Certificate{
-		SerialNumber: serial,
-		Subject: pkix.Name{
-			CommonName: name,
-		},
-		NotBefore:             time.Date(2010, 12, 31, 23, 59, 59, 0, time.UTC),
-		NotAfter:              time.Date(2049, 12, 31, 23, 59, 59, 0, time.UTC),
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		SerialNumber:          serial,
+		Subject:               subject,
 		BasicConstraintsValid: true,
-	}
-	if !isCA {
-		cert.Subject.CommonName = host
-		ip := net.ParseIP(host)
-		if ip != nil {
-			cert.IPAddresses = append(cert.IPAddresses, ip)
-		}
+		ExtraExtensions:       extra,
 	}
 	if isCA {
 		cert.IsCA = true
-		cert.MaxPathLen = 16
-		cert.KeyUsage |= x509.KeyUsageCertSign
+		cert.KeyUsage = caKeyUsageFor(pub)
 	}
 	return cert
 }
 
-func (ca *Parcel) derCRLBytes(revoked []int64) ([]byte, error) {
-	rev := make([]pkix.RevokedCertificate, 0)
-	for _, val := range revoked {
-		r := pkix.RevokedCertificate{
-			SerialNumber:   big.NewInt(val),
-			RevocationTime: time.Now(),
-		}
-		rev = append(rev, r)
+// PEMChain returns the PEM certificates of parcels, in order.
+func PEMChain(parcels []*Parcel) io.Reader {
+	var buf bytes.Buffer
+	for _, p := range parcels {
+		pem.Encode(&buf, &pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: p.DERCertificateBytes,
+		})
 	}
-
-	return ca.Certificate.CreateCRL(
-		rand.Reader,
-		ca.PrivateKey,
-		rev,
-		time.Now(),
-		time.Now().Add(time.Duration(1)*time.Minute))
+	return &buf
 }
 
-func (ca *Parcel) DERCRL(revoked []int64) (io.Reader, error) {
-	crlBytes, err := ca.derCRLBytes(revoked)
+// derCRLBytes has the CA sign a CRL listing entries.
+func (ca *Parcel) derCRLBytes(entries []x509.RevocationListEntry, number *big.Int, thisUpdate, nextUpdate time.Time, extensions []pkix.Extension) ([]byte, error) {
+	signer := ca.Signer()
+	if signer == nil {
+		return nil, fmt.Errorf("CA %v has no private key", ca.ID)
+	}
+	sigAlg, err := ca.x509SignatureAlgorithm()
 	if err != nil {
 		return nil, err
 	}
-	return bytes.NewBuffer(crlBytes), nil
+
+	// CAs created by earlier versions lack the cRLSign key usage, which
+	// CreateRevocationList insists on; their CRLs are signed as before.
+	issuer := *ca.Certificate
+	issuer.KeyUsage |= x509.KeyUsageCRLSign
+	return x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		SignatureAlgorithm:        sigAlg,
+		RevokedCertificateEntries: entries,
+		Number:                    number,
+		ThisUpdate:                thisUpdate,
+		NextUpdate:                nextUpdate,
+		ExtraExtensions:           extensions,
+	}, &issuer, signer)
 }
 
-func (ca *Parcel) PEMCRL(revoked []int64) (io.Reader, error) {
-	crlBytes, err := ca.derCRLBytes(revoked)
-	if err != nil {
-		return nil, err
-	}
+// pemCRL encodes the DER encoded CRL der in PEM.
+func pemCRL(der []byte) io.Reader {
 	block := &pem.Block{
 		Type:  "X509 CRL",
-		Bytes: crlBytes,
+		Bytes: der,
 	}
-	data := pem.EncodeToMemory(block)
-	return bytes.NewBuffer(data), nil
+	return bytes.NewBuffer(pem.EncodeToMemory(block))
 }
 
 func (p *Parcel) DERCertificate() io.Reader {
 	return bytes.NewBuffer(p.DERCertificateBytes)
 }
 
+// DERPrivateKey returns RSA keys in PKCS#1 form and all other keys in PKCS#8 form.
 func (p *Parcel) DERPrivateKey() io.Reader {
-	data := x509.MarshalPKCS1PrivateKey(p.PrivateKey)
-	return bytes.NewBuffer(data)
+	if key, ok := p.Signer().(*rsa.PrivateKey); ok {
+		return bytes.NewBuffer(x509.MarshalPKCS1PrivateKey(key))
+	}
+	return bytes.NewBuffer(p.PKCS8PrivateKey)
 }
 
 func (p *Parcel) PEMCertificate() io.Reader {
@@ -259,8 +629,18 @@ func (p *Parcel) PEMCertificate() io.Reader {
 
 func (p *Parcel) PEMPrivateKey() io.Reader {
 	block := &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(p.PrivateKey),
+		Type:  "PRIVATE KEY",
+		Bytes: p.PKCS8PrivateKey,
+	}
+	switch key := p.Signer().(type) {
+	case *rsa.PrivateKey:
+		block.Type = "RSA PRIVATE KEY"
+		block.Bytes = x509.MarshalPKCS1PrivateKey(key)
+	case *ecdsa.PrivateKey:
+		if der, err := x509.MarshalECPrivateKey(key); err == nil {
+			block.Type = "EC PRIVATE KEY"
+			block.Bytes = der
+		}
 	}
 	data := pem.EncodeToMemory(block)
 	return bytes.NewBuffer(data)
@@ -0,0 +1,230 @@
+package liftca
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC     = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+// maxPBKDF2Iterations bounds the work an encrypted private key can ask for,
+// well above the few hundred thousand iterations tools use, so that a
+// crafted key cannot tie up a request for minutes.
+const maxPBKDF2Iterations = 10000000
+
+// parsePEMPrivateKey returns the private key held in the PEM block data:
+// PKCS#1 RSA keys, SEC1 EC keys and PKCS#8 keys, either unencrypted,
+// encrypted in PKCS#8 form with PBES2, or encrypted with the legacy PEM
+// headers of OpenSSL.
+func parsePEMPrivateKey(data, password []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("Invalid PEM block")
+	}
+	return parsePrivateKeyBlock(block, password)
+}
+
+// parsePEMPrivateKeys returns all the private keys held in the PEM blocks
+// of data, all encrypted with password if they are.
+func parsePEMPrivateKeys(data, password []byte) ([]crypto.Signer, error) {
+	keys := make([]crypto.Signer, 0)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return keys, nil
+		}
+		key, err := parsePrivateKeyBlock(block, password)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+}
+
+func parsePrivateKeyBlock(block *pem.Block, password []byte) (crypto.Signer, error) {
+	der := block.Bytes
+	if x509.IsEncryptedPEMBlock(block) {
+		if len(password) == 0 {
+			return nil, fmt.Errorf("Private key is encrypted; a password is required")
+		}
+		var err error
+		der, err = x509.DecryptPEMBlock(block, password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(der)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(der)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(der)
+	case "ENCRYPTED PRIVATE KEY":
+		if len(password) == 0 {
+			return nil, fmt.Errorf("Private key is encrypted; a password is required")
+		}
+		der, err = decryptPKCS8(der, password)
+		if err != nil {
+			return nil, err
+		}
+		key, err = x509.ParsePKCS8PrivateKey(der)
+	default:
+		return nil, fmt.Errorf("Invalid PEM block; unsupported private key type '%v'", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return asSigner(key)
+}
+
+// parsePKCS12 returns the key, certificate and other certificates of a
+// PKCS#12 (.p12 or .pfx) bundle.
+func parsePKCS12(pfx, password []byte) (crypto.Signer, *x509.Certificate, []*x509.Certificate, error) {
+	key, cert, caCerts, err := pkcs12.DecodeChain(pfx, string(password))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	signer, err := asSigner(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return signer, cert, caCerts, nil
+}
+
+func asSigner(key interface{}) (crypto.Signer, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("Unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// decryptPKCS8 decrypts an EncryptedPrivateKeyInfo (RFC 5958) protected
+// with PBES2 and PBKDF2 (RFC 8018), as written by OpenSSL, and returns the
+// PKCS#8 private key it holds.
+func decryptPKCS8(der, password []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("Unsupported private key encryption %v; only PBES2 is supported", info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("Unsupported key derivation function %v", params.KeyDerivationFunc.Algorithm)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, err
+	}
+	if kdf.IterationCount < 1 || kdf.IterationCount > maxPBKDF2Iterations {
+		return nil, fmt.Errorf("Unsupported PBKDF2 iteration count %v; at most %v are allowed", kdf.IterationCount, maxPBKDF2Iterations)
+	}
+
+	var prf func() hash.Hash
+	switch {
+	case len(kdf.PRF.Algorithm) == 0 || kdf.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA384):
+		prf = sha512.New384
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA512):
+		prf = sha512.New
+	default:
+		return nil, fmt.Errorf("Unsupported PBKDF2 function %v", kdf.PRF.Algorithm)
+	}
+
+	var keyLen int
+	var newCipher func([]byte) (cipher.Block, error)
+	switch scheme := params.EncryptionScheme.Algorithm; {
+	case scheme.Equal(oidAES128CBC):
+		keyLen, newCipher = 16, aes.NewCipher
+	case scheme.Equal(oidAES192CBC):
+		keyLen, newCipher = 24, aes.NewCipher
+	case scheme.Equal(oidAES256CBC):
+		keyLen, newCipher = 32, aes.NewCipher
+	case scheme.Equal(oidDESEDE3CBC):
+		keyLen, newCipher = 24, des.NewTripleDESCipher
+	default:
+		return nil, fmt.Errorf("Unsupported private key cipher %v", scheme)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+
+	block, err := newCipher(pbkdf2.Key(password, kdf.Salt, kdf.IterationCount, keyLen, prf))
+	if err != nil {
+		return nil, err
+	}
+	data := info.EncryptedData
+	if len(iv) != block.BlockSize() || len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return nil, fmt.Errorf("Invalid encrypted private key")
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	// A wrong password shows up as invalid padding.
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > block.BlockSize() {
+		return nil, fmt.Errorf("Incorrect password for private key")
+	}
+	for _, b := range plain[len(plain)-pad:] {
+		if int(b) != pad {
+			return nil, fmt.Errorf("Incorrect password for private key")
+		}
+	}
+	return plain[:len(plain)-pad], nil
+}
@@ -0,0 +1,154 @@
+package liftca
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+)
+
+// Profile names a set of key usages for issued certificates.
+type Profile string
+
+const (
+	ProfileServer       Profile = "server"
+	ProfileClient       Profile = "client"
+	ProfileServerClient Profile = "server-client"
+	ProfileCodeSigning  Profile = "code-signing"
+	ProfileEmail        Profile = "email"
+	ProfileOCSPSigning  Profile = "ocsp-signing"
+	ProfileTimestamping Profile = "timestamping"
+)
+
+// DefaultProfile is used when a request does not ask for a specific profile.
+const DefaultProfile = ProfileServer
+
+var (
+	oidExtensionExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+	oidExtKeyUsageTimeStamping   = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 8}
+	oidExtensionOCSPNoCheck      = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+)
+
+type profile struct {
+	// keyUsage is added to digitalSignature, which every profile has.
+	keyUsage x509.KeyUsage
+	// keyEncipherment adds keyEncipherment for RSA keys, for TLS key exchange
+	// and S/MIME encryption.
+	keyEncipherment bool
+	extKeyUsage     []x509.ExtKeyUsage
+	// criticalTimeStamping replaces extKeyUsage with a critical extension
+	// holding only id-kp-timeStamping, as RFC 3161 requires.
+	criticalTimeStamping bool
+	// ocspNoCheck adds id-pkix-ocsp-nocheck (RFC 6960, section 4.2.2.2.1).
+	ocspNoCheck bool
+}
+
+var profiles = map[Profile]profile{
+	ProfileServer: {
+		keyEncipherment: true,
+		extKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	},
+	ProfileClient: {
+		extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	},
+	ProfileServerClient: {
+		keyEncipherment: true,
+		extKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	},
+	ProfileCodeSigning: {
+		extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	},
+	ProfileEmail: {
+		keyUsage:        x509.KeyUsageContentCommitment,
+		keyEncipherment: true,
+		extKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	},
+	ProfileOCSPSigning: {
+		extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+		ocspNoCheck: true,
+	},
+	ProfileTimestamping: {
+		keyUsage:             x509.KeyUsageContentCommitment,
+		extKeyUsage:          []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+		criticalTimeStamping: true,
+	},
+}
+
+// apply sets the key usages of cert, whose public key is pub, according to
+// the profile.
+func (p Profile) apply(cert *x509.Certificate, pub crypto.PublicKey) error {
+	if p == "" {
+		p = DefaultProfile
+	}
+	pr, found := profiles[p]
+	if !found {
+		return fmt.Errorf("unknown certificate profile '%v'", p)
+	}
+
+	cert.KeyUsage = x509.KeyUsageDigitalSignature | pr.keyUsage
+	if _, ok := pub.(*rsa.PublicKey); ok && pr.keyEncipherment {
+		cert.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	cert.ExtKeyUsage = pr.extKeyUsage
+	if pr.criticalTimeStamping {
+		value, err := asn1.Marshal([]asn1.ObjectIdentifier{oidExtKeyUsageTimeStamping})
+		if err != nil {
+			return err
+		}
+		cert.ExtraExtensions = append(cert.ExtraExtensions, pkix.Extension{
+			Id:       oidExtensionExtendedKeyUsage,
+			Critical: true,
+			Value:    value,
+		})
+	}
+	if pr.ocspNoCheck {
+		cert.ExtraExtensions = append(cert.ExtraExtensions, pkix.Extension{
+			Id:    oidExtensionOCSPNoCheck,
+			Value: asn1.NullBytes,
+		})
+	}
+	return nil
+}
+
+// profileOf returns the profile whose extended key usages match those of
+// cert, in any order, or the empty Profile if there is none.
+func profileOf(cert *x509.Certificate) Profile {
+	for name, pr := range profiles {
+		if sameExtKeyUsages(cert.ExtKeyUsage, pr.extKeyUsage) {
+			return name
+		}
+	}
+	return ""
+}
+
+// matches reports whether cert has exactly the key usages and extended key
+// usages that the profile gives to certificates for its public key.
+func (p Profile) matches(cert *x509.Certificate) bool {
+	if p == "" || len(cert.UnknownExtKeyUsage) > 0 {
+		return false
+	}
+	var template x509.Certificate
+	if err := p.apply(&template, cert.PublicKey); err != nil {
+		return false
+	}
+	return cert.KeyUsage == template.KeyUsage && sameExtKeyUsages(cert.ExtKeyUsage, template.ExtKeyUsage)
+}
+
+func sameExtKeyUsages(a, b []x509.ExtKeyUsage) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := make(map[x509.ExtKeyUsage]int)
+	for _, u := range a {
+		count[u]++
+	}
+	for _, u := range b {
+		if count[u] == 0 {
+			return false
+		}
+		count[u]--
+	}
+	return true
+}
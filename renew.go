@@ -0,0 +1,65 @@
+package liftca
+
+import (
+	"crypto"
+	"fmt"
+	"math/big"
+)
+
+// RenewRequest describes the renewal of a certificate: its successor has
+// the same subject, names, profile, policies and extensions, and a fresh
+// validity period.
+type RenewRequest struct {
+	// Rekey gives the successor a new key of type KeyType, which defaults
+	// to that of the renewed certificate, instead of the renewed
+	// certificate's key.
+	Rekey    bool
+	KeyType  KeyType
+	Validity Validity
+}
+
+// makeRenewedParcel has issuers[0] issue the successor of the certificate
+// old, as described by req.
+func makeRenewedParcel(visible bool, id int64, serial *big.Int, issuers []*Parcel, dp DistributionPoints, old *Parcel, req *RenewRequest) (*Parcel, error) {
+	if old.Certificate.IsCA {
+		return nil, fmt.Errorf("certificate %v is a CA; CAs cannot be renewed", old.ID)
+	}
+	// The profile is all that is kept of the key usages, so the
+	// certificate must have exactly those of its profile.
+	profile := old.Profile()
+	if !profile.matches(old.Certificate) {
+		return nil, fmt.Errorf("certificate %v does not have the key usages of any profile; it cannot be renewed", old.ID)
+	}
+	renewed := &CertRequest{
+		Subject:        old.Certificate.Subject,
+		Profile:        profile,
+		DNSNames:       old.Certificate.DNSNames,
+		IPAddresses:    old.Certificate.IPAddresses,
+		EmailAddresses: old.Certificate.EmailAddresses,
+		URIs:           old.Certificate.URIs,
+		Validity:       req.Validity,
+		Policies:       old.Policies(),
+		Extensions:     customExtensionsOf(old.Certificate.Extensions),
+	}
+	if err := renewed.validate(); err != nil {
+		return nil, err
+	}
+
+	var pub crypto.PublicKey
+	var key crypto.Signer
+	if req.Rekey {
+		keyType := req.KeyType
+		if keyType == "" {
+			keyType = old.KeyType()
+		}
+		var err error
+		key, err = newKey(keyType)
+		if err != nil {
+			return nil, err
+		}
+		pub = key.Public()
+	} else {
+		pub, key = old.PublicKey(), old.Signer()
+	}
+	return signParcel(visible, id, serial, issuers, dp, renewed, pub, key)
+}
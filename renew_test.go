@@ -0,0 +1,117 @@
+package liftca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestRenew(t *testing.T) {
+	s := NewStore()
+	ca := addTestCA(t, s, "root", CASettings{})
+	id, err := s.Add(true, ca, &CertRequest{KeyType: KeyTypeECDSAP256, Profile: ProfileClient, EmailAddresses: []string{"me@team.lab"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := getTestParcel(t, s, id)
+
+	for _, req := range []RenewRequest{{}, {Rekey: true, KeyType: KeyTypeEd25519}} {
+		renewed, err := s.Renew(id, &req)
+		if err != nil {
+			t.Fatalf("renewing with %+v: %v", req, err)
+		}
+		p := getTestParcel(t, s, renewed)
+		if p.Profile() != ProfileClient || p.Certificate.KeyUsage != old.Certificate.KeyUsage {
+			t.Errorf("renewed certificate has profile %q and key usages %v", p.Profile(), p.Certificate.KeyUsage)
+		}
+		if p.Certificate.Subject.String() != old.Certificate.Subject.String() || len(p.Certificate.EmailAddresses) != 1 {
+			t.Errorf("renewed certificate is for %v, %v", p.Certificate.Subject, p.Certificate.EmailAddresses)
+		}
+		if samePublicKey := p.Certificate.PublicKeyAlgorithm == old.Certificate.PublicKeyAlgorithm; samePublicKey == req.Rekey {
+			t.Errorf("renewed certificate has a %v key", p.Certificate.PublicKeyAlgorithm)
+		}
+		if predecessor, found := s.GetPredecessor(renewed); !found || predecessor != id {
+			t.Errorf("predecessor of the renewed certificate is %v, %v", predecessor, found)
+		}
+	}
+	if successors := s.GetSuccessors(id); len(successors) != 2 {
+		t.Errorf("certificate has successors %v", successors)
+	}
+}
+
+// issueTestCert has the CA ca of s sign template directly, bypassing
+// profiles, and imports the result.
+func issueTestCert(t *testing.T, s *Store, ca int64, template *x509.Certificate) int64 {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := getTestParcel(t, s, ca)
+	template.SerialNumber = big.NewInt(1000)
+	template.Subject = pkix.Name{CommonName: "www.lab"}
+	template.DNSNames = []string{"www.lab"}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer.Certificate, key.Public(), issuer.Signer())
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, err := s.AddExistingCerts(true, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported[0].Err != nil {
+		t.Fatal(imported[0].Err)
+	}
+	return imported[0].ID
+}
+
+// Renewing keeps the key usages through the profile, so certificates whose
+// key usages are those of no profile cannot be renewed.
+func TestRenewKeepsKeyUsages(t *testing.T) {
+	s := NewStore()
+	ca := addTestCA(t, s, "root", CASettings{})
+
+	reordered := issueTestCert(t, s, ca, &x509.Certificate{
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	})
+	if p := getTestParcel(t, s, reordered); p.Profile() != ProfileServerClient {
+		t.Errorf("profile of reordered extended key usages is %q", p.Profile())
+	}
+	renewed, err := s.Renew(reordered, &RenewRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := getTestParcel(t, s, renewed); p.Profile() != ProfileServerClient {
+		t.Errorf("renewed certificate has profile %q", p.Profile())
+	}
+
+	for name, template := range map[string]*x509.Certificate{
+		"no profile": {
+			KeyUsage:    x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageCodeSigning},
+		},
+		"other key usages": {
+			KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyAgreement,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		},
+	} {
+		s := NewStore()
+		ca := addTestCA(t, s, "root", CASettings{})
+		id := issueTestCert(t, s, ca, template)
+		if _, err := s.Renew(id, &RenewRequest{}); err == nil {
+			t.Errorf("%v: certificate renewed", name)
+		}
+		if successors := s.GetSuccessors(id); len(successors) != 0 {
+			t.Errorf("%v: certificate has successors %v", name, successors)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package liftca
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// RevocationReason is a CRLReason code (RFC 5280, section 5.3.1).
+type RevocationReason int
+
+const (
+	ReasonUnspecified          RevocationReason = 0
+	ReasonKeyCompromise        RevocationReason = 1
+	ReasonCACompromise         RevocationReason = 2
+	ReasonAffiliationChanged   RevocationReason = 3
+	ReasonSuperseded           RevocationReason = 4
+	ReasonCessationOfOperation RevocationReason = 5
+	ReasonCertificateHold      RevocationReason = 6
+	ReasonRemoveFromCRL        RevocationReason = 8
+	ReasonPrivilegeWithdrawn   RevocationReason = 9
+	ReasonAACompromise         RevocationReason = 10
+)
+
+var revocationReasonNames = map[RevocationReason]string{
+	ReasonUnspecified:          "unspecified",
+	ReasonKeyCompromise:        "keyCompromise",
+	ReasonCACompromise:         "cACompromise",
+	ReasonAffiliationChanged:   "affiliationChanged",
+	ReasonSuperseded:           "superseded",
+	ReasonCessationOfOperation: "cessationOfOperation",
+	ReasonCertificateHold:      "certificateHold",
+	ReasonRemoveFromCRL:        "removeFromCRL",
+	ReasonPrivilegeWithdrawn:   "privilegeWithdrawn",
+	ReasonAACompromise:         "aACompromise",
+}
+
+// String returns the name of the reason in RFC 5280, such as
+// 'keyCompromise'.
+func (r RevocationReason) String() string {
+	if name, found := revocationReasonNames[r]; found {
+		return name
+	}
+	return fmt.Sprintf("reason %d", int(r))
+}
+
+// ParseRevocationReason returns the reason named name in RFC 5280, such as
+// 'keyCompromise'; the empty name is ReasonUnspecified.
+func ParseRevocationReason(name string) (RevocationReason, error) {
+	if name == "" {
+		return ReasonUnspecified, nil
+	}
+	for r, n := range revocationReasonNames {
+		if n == name {
+			return r, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown revocation reason '%v'", name)
+}
+
+var (
+	oidExtensionInvalidityDate    = asn1.ObjectIdentifier{2, 5, 29, 24}
+	oidExtensionDeltaCRLIndicator = asn1.ObjectIdentifier{2, 5, 29, 27}
+)
+
+// Revocation records the revocation of a certificate.
+type Revocation struct {
+	Time   time.Time
+	Reason RevocationReason
+	// InvalidityDate is when the certificate is known or suspected to have
+	// become invalid, such as when its key was compromised; zero if it is
+	// not known.
+	InvalidityDate time.Time
+	// RevokedBy says who revoked the certificate, in free form.
+	RevokedBy string
+}
+
+func (r *Revocation) validate() error {
+	if _, found := revocationReasonNames[r.Reason]; !found {
+		return fmt.Errorf("unknown revocation reason %d", int(r.Reason))
+	}
+	if r.Reason == ReasonRemoveFromCRL {
+		return fmt.Errorf("certificates cannot be revoked with reason removeFromCRL")
+	}
+	if r.InvalidityDate.After(r.Time) {
+		return fmt.Errorf("invalidity date (%v) cannot be later than the revocation (%v)", r.InvalidityDate, r.Time)
+	}
+	return nil
+}
+
+// extensions returns the CRL entry extensions, or OCSP single response
+// extensions, that carry the parts of the revocation other than its time
+// and reason.
+func (r *Revocation) extensions() ([]pkix.Extension, error) {
+	if r.InvalidityDate.IsZero() {
+		return nil, nil
+	}
+	value, err := asn1.MarshalWithParams(r.InvalidityDate.UTC(), "generalized")
+	if err != nil {
+		return nil, err
+	}
+	return []pkix.Extension{{Id: oidExtensionInvalidityDate, Value: value}}, nil
+}
+
+// entry returns the CRL entry of the certificate serial, revoked as r says.
+func (r *Revocation) entry(serial *big.Int) (x509.RevocationListEntry, error) {
+	extensions, err := r.extensions()
+	if err != nil {
+		return x509.RevocationListEntry{}, err
+	}
+	return x509.RevocationListEntry{
+		SerialNumber:    serial,
+		RevocationTime:  r.Time,
+		ReasonCode:      int(r.Reason),
+		ExtraExtensions: extensions,
+	}, nil
+}
+
+// revocationEvent is a change to the revocation of a certificate: its
+// revocation, or its unrevocation, recorded with reason removeFromCRL.
+type revocationEvent struct {
+	ID         int64
+	Revocation Revocation
+}
+
+// deltaCRLIndicator returns the Delta CRL Indicator extension of a delta
+// CRL relative to the complete CRL numbered base (RFC 5280, section 5.2.4).
+func deltaCRLIndicator(base *big.Int) (pkix.Extension, error) {
+	value, err := asn1.Marshal(base)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidExtensionDeltaCRLIndicator, Critical: true, Value: value}, nil
+}
+
+// issuedCRL is the last CRL, or delta CRL, issued by a CA.
+type issuedCRL struct {
+	Number *big.Int
+	// BaseNumber is the number of the complete CRL a delta CRL is relative
+	// to; nil for complete CRLs.
+	BaseNumber *big.Int
+	ThisUpdate time.Time
+	NextUpdate time.Time
+	// Events is how many events of the revocation log of the CA the CRL
+	// reflects.
+	Events int
+	// DER is nil once the CRL no longer lists the current revocations.
+	DER []byte
+}
+
+// fresh reports whether the CRL can still be handed out at now: it lists
+// the current revocations, and has more than half its validity left.
+func (c *issuedCRL) fresh(now time.Time) bool {
+	if c == nil || c.DER == nil {
+		return false
+	}
+	return now.Before(c.ThisUpdate.Add(c.NextUpdate.Sub(c.ThisUpdate) / 2))
+}
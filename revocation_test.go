@@ -0,0 +1,231 @@
+package liftca
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"io"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// getTestCRL fetches and parses the CRL or delta CRL of the CA id with get,
+// such as Store.DERCRL.
+func getTestCRL(t *testing.T, get func(int64) (io.Reader, error), id int64) *x509.RevocationList {
+	t.Helper()
+	r, err := get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crl
+}
+
+// crlReasons maps the serial numbers listed by crl to their reason codes.
+func crlReasons(crl *x509.RevocationList) map[string]int {
+	reasons := make(map[string]int)
+	for _, entry := range crl.RevokedCertificateEntries {
+		reasons[entry.SerialNumber.String()] = entry.ReasonCode
+	}
+	return reasons
+}
+
+func TestParseRevocationReason(t *testing.T) {
+	for reason, name := range revocationReasonNames {
+		got, err := ParseRevocationReason(name)
+		if err != nil || got != reason {
+			t.Errorf("ParseRevocationReason(%q) = %v, %v", name, got, err)
+		}
+		if reason.String() != name {
+			t.Errorf("%d.String() = %q, want %q", int(reason), reason.String(), name)
+		}
+	}
+	if _, err := ParseRevocationReason("bored"); err == nil {
+		t.Error("unknown reason accepted")
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	s := NewStore()
+	ca := addTestCA(t, s, "root", CASettings{})
+	id := addTestCert(t, s, ca, "www.lab")
+
+	if err := s.Revoke(id, Revocation{Reason: ReasonRemoveFromCRL}); err == nil {
+		t.Error("revoked with reason removeFromCRL")
+	}
+	if err := s.Revoke(id, Revocation{Reason: ReasonCertificateHold}); err != nil {
+		t.Fatal(err)
+	}
+	// A certificate on hold may be revoked for good.
+	if err := s.Revoke(id, Revocation{Reason: ReasonKeyCompromise, RevokedBy: "admin"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Revoke(id, Revocation{Reason: ReasonSuperseded}); err == nil {
+		t.Error("revoked certificate revoked again")
+	}
+	r, found := s.GetRevocation(id)
+	if !found || r.Reason != ReasonKeyCompromise || r.RevokedBy != "admin" || r.Time.IsZero() {
+		t.Errorf("revocation is %+v, %v", r, found)
+	}
+	s.Unrevoke(id)
+	if s.IsRevoked(id) {
+		t.Error("certificate still revoked")
+	}
+}
+
+func TestCRLNumbers(t *testing.T) {
+	s := NewStore()
+	ca := addTestCA(t, s, "root", CASettings{})
+	id := addTestCert(t, s, ca, "www.lab")
+	serial := getTestParcel(t, s, id).SerialNumber().String()
+
+	crl := getTestCRL(t, s.DERCRL, ca)
+	if crl.Number.Cmp(big.NewInt(1)) != 0 || len(crl.RevokedCertificateEntries) != 0 {
+		t.Fatalf("first CRL is number %v with %v entries", crl.Number, len(crl.RevokedCertificateEntries))
+	}
+	// The CRL is only reissued when the revocations change.
+	if crl = getTestCRL(t, s.DERCRL, ca); crl.Number.Cmp(big.NewInt(1)) != 0 {
+		t.Errorf("unchanged CRL reissued as number %v", crl.Number)
+	}
+
+	invalid := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	if err := s.Revoke(id, Revocation{Reason: ReasonKeyCompromise, InvalidityDate: invalid}); err != nil {
+		t.Fatal(err)
+	}
+	crl = getTestCRL(t, s.DERCRL, ca)
+	if crl.Number.Cmp(big.NewInt(2)) != 0 {
+		t.Errorf("CRL after a revocation is number %v, want 2", crl.Number)
+	}
+	if reasons := crlReasons(crl); reasons[serial] != int(ReasonKeyCompromise) {
+		t.Errorf("CRL entries are %v", reasons)
+	}
+	oidInvalidityDate := asn1.ObjectIdentifier{2, 5, 29, 24}
+	var hasInvalidityDate bool
+	for _, ext := range crl.RevokedCertificateEntries[0].Extensions {
+		hasInvalidityDate = hasInvalidityDate || ext.Id.Equal(oidInvalidityDate)
+	}
+	if !hasInvalidityDate {
+		t.Error("CRL entry lacks the invalidity date")
+	}
+	if n, _ := s.GetCRLNumber(ca); n.Cmp(crl.Number) != 0 {
+		t.Errorf("GetCRLNumber is %v, want %v", n, crl.Number)
+	}
+
+	s.Unrevoke(id)
+	crl = getTestCRL(t, s.DERCRL, ca)
+	if crl.Number.Cmp(big.NewInt(3)) != 0 || len(crl.RevokedCertificateEntries) != 0 {
+		t.Errorf("CRL after unrevoking is number %v with %v entries", crl.Number, len(crl.RevokedCertificateEntries))
+	}
+}
+
+func TestCRLReissuedAtHalfLife(t *testing.T) {
+	s := NewStore()
+	ca := addTestCA(t, s, "root", CASettings{CRLTTL: time.Hour})
+	getTestCRL(t, s.DERCRL, ca)
+
+	// Age the CRL past half its validity.
+	s.withLocked(func() {
+		c := s.crls[ca]
+		c.ThisUpdate = c.ThisUpdate.Add(-40 * time.Minute)
+		c.NextUpdate = c.NextUpdate.Add(-40 * time.Minute)
+	})
+	crl := getTestCRL(t, s.DERCRL, ca)
+	if crl.Number.Cmp(big.NewInt(2)) != 0 {
+		t.Errorf("stale CRL not reissued: number %v", crl.Number)
+	}
+	if got := crl.NextUpdate.Sub(crl.ThisUpdate); got != time.Hour {
+		t.Errorf("CRL is valid for %v, want %v", got, time.Hour)
+	}
+}
+
+func TestDeltaCRLs(t *testing.T) {
+	s := NewStore()
+	testDeltaDistribution(s)
+	ca := addTestCA(t, s, "root", CASettings{DeltaCRLTTL: 10 * time.Minute})
+	held := addTestCert(t, s, ca, "held.lab")
+	compromised := addTestCert(t, s, ca, "compromised.lab")
+	heldSerial := getTestParcel(t, s, held).SerialNumber().String()
+	compromisedSerial := getTestParcel(t, s, compromised).SerialNumber().String()
+
+	if err := s.Revoke(held, Revocation{Reason: ReasonCertificateHold}); err != nil {
+		t.Fatal(err)
+	}
+	base := getTestCRL(t, s.DERCRL, ca)
+	if freshest := freshestCRLCount(&x509.Certificate{Extensions: base.Extensions}); freshest != 1 {
+		t.Errorf("CRL has %v FreshestCRL extensions, want 1", freshest)
+	}
+	delta := getTestCRL(t, s.DERDeltaCRL, ca)
+	if delta.Number.Cmp(new(big.Int).Add(base.Number, big.NewInt(1))) != 0 {
+		t.Errorf("delta CRL is number %v after CRL %v", delta.Number, base.Number)
+	}
+	if len(delta.RevokedCertificateEntries) != 0 {
+		t.Errorf("delta CRL lists %v", crlReasons(delta))
+	}
+
+	// Changes go into delta CRLs, while the complete CRL stays as is.
+	if err := s.Revoke(compromised, Revocation{Reason: ReasonKeyCompromise}); err != nil {
+		t.Fatal(err)
+	}
+	s.Unrevoke(held)
+	if crl := getTestCRL(t, s.DERCRL, ca); crl.Number.Cmp(base.Number) != 0 {
+		t.Errorf("CRL reissued as number %v on a change", crl.Number)
+	}
+	next := getTestCRL(t, s.DERDeltaCRL, ca)
+	if next.Number.Cmp(delta.Number) <= 0 {
+		t.Errorf("delta CRL number %v not after %v", next.Number, delta.Number)
+	}
+	want := map[string]int{
+		compromisedSerial: int(ReasonKeyCompromise),
+		heldSerial:        int(ReasonRemoveFromCRL),
+	}
+	got := crlReasons(next)
+	if len(got) != len(want) || got[compromisedSerial] != want[compromisedSerial] || got[heldSerial] != want[heldSerial] {
+		t.Errorf("delta CRL entries are %v, want %v", got, want)
+	}
+	if n, _ := s.GetDeltaCRLNumber(ca); n.Cmp(next.Number) != 0 {
+		t.Errorf("GetDeltaCRLNumber is %v, want %v", n, next.Number)
+	}
+
+	// CAs without delta CRLs do not publish them.
+	plain := addTestCA(t, s, "plain", CASettings{})
+	if _, err := s.DERDeltaCRL(plain); err == nil {
+		t.Error("delta CRL issued by a CA without delta CRLs")
+	}
+}
+
+// Handing out CRLs that are still fresh does not change the store, so it
+// must not signal its listeners, which save and publish it.
+func TestFreshCRLsDoNotSignalUpdates(t *testing.T) {
+	s := NewStore()
+	ca := addTestCA(t, s, "root", CASettings{DeltaCRLTTL: 10 * time.Minute})
+	getTestCRL(t, s.DERDeltaCRL, ca)
+
+	updates := make(chan struct{}, 16)
+	s.Updates(updates)
+	for i := 0; i < 3; i++ {
+		getTestCRL(t, s.DERCRL, ca)
+		getTestCRL(t, s.DERDeltaCRL, ca)
+	}
+	if n := len(updates); n != 0 {
+		t.Errorf("fetching fresh CRLs signalled %v updates", n)
+	}
+
+	id := addTestCert(t, s, ca, "www.lab")
+	if err := s.Revoke(id, Revocation{}); err != nil {
+		t.Fatal(err)
+	}
+	for len(updates) > 0 {
+		<-updates
+	}
+	getTestCRL(t, s.DERDeltaCRL, ca)
+	if n := len(updates); n != 1 {
+		t.Errorf("issuing a delta CRL signalled %v updates, want 1", n)
+	}
+}
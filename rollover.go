@@ -0,0 +1,130 @@
+package liftca
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+)
+
+// RolloverRequest describes the next generation of a root CA: it has the
+// same subject, path length and name constraints, policies, extensions and
+// settings as the current one, and a new key.
+type RolloverRequest struct {
+	// KeyType defaults to that of the current root.
+	KeyType  KeyType
+	Validity Validity
+}
+
+// Rollover identifies the certificates created by a root CA key rollover.
+type Rollover struct {
+	// NewCA is the new root CA.
+	NewCA int64
+	// NewSignedByOld is the cross certificate for the key of the new root,
+	// issued by the old one.
+	NewSignedByOld int64
+	// OldSignedByNew is the cross certificate for the key of the old root,
+	// issued by the new one.
+	OldSignedByNew int64
+}
+
+// rolloverCARequest returns the request for the next generation of the root
+// CA ca.
+func rolloverCARequest(ca *Parcel, req *RolloverRequest) (*CARequest, error) {
+	if !ca.IsSelfSigned() {
+		return nil, fmt.Errorf("CA %v is not a root CA; issue a new intermediate CA instead", ca.ID)
+	}
+	if !ca.HasPrivateKey() {
+		return nil, fmt.Errorf("CA %v has no private key", ca.ID)
+	}
+	keyType := req.KeyType
+	if keyType == "" {
+		keyType = ca.KeyType()
+	}
+	var maxPathLen *int
+	if ca.Certificate.MaxPathLen > 0 || ca.Certificate.MaxPathLenZero {
+		n := ca.Certificate.MaxPathLen
+		maxPathLen = &n
+	}
+	var settings CASettings
+	if ca.Settings != nil {
+		settings = *ca.Settings
+	}
+	return &CARequest{
+		Subject:         ca.Certificate.Subject,
+		KeyType:         keyType,
+		Validity:        req.Validity,
+		MaxPathLen:      maxPathLen,
+		NameConstraints: ca.NameConstraints(),
+		Policies:        ca.Policies(),
+		Extensions:      customExtensionsOf(ca.Certificate.Extensions),
+		Settings:        settings,
+	}, nil
+}
+
+// makeCrossParcel has issuer certify the key of the CA subject.  The cross
+// certificate has the subject, key, constraints, policies and extensions of
+// the certificate of subject, and is valid while both CAs are.  The parcel
+// has no private key: subject holds it.
+func makeCrossParcel(visible bool, id int64, serial *big.Int, issuer, subject *Parcel, dp DistributionPoints) (p *Parcel, err error) {
+	issuerKey := issuer.Signer()
+	if issuerKey == nil {
+		err = fmt.Errorf("CA %v has no private key", issuer.ID)
+		return
+	}
+	limit, err := (&CARequest{}).maxPathLen(issuer.Certificate)
+	if err != nil {
+		return
+	}
+	maxPathLen := subject.Certificate.MaxPathLen
+	if maxPathLen < 0 || maxPathLen > limit {
+		maxPathLen = limit
+	}
+	extra, err := extraExtensions(subject.Policies(), customExtensionsOf(subject.Certificate.Extensions))
+	if err != nil {
+		return
+	}
+
+	pub := subject.PublicKey()
+	cert := makeCertTemplate(true, subject.Certificate.Subject, serial, pub, extra)
+	cert.KeyUsage = subject.Certificate.KeyUsage
+	cert.NotBefore = subject.Certificate.NotBefore
+	if issuer.Certificate.NotBefore.After(cert.NotBefore) {
+		cert.NotBefore = issuer.Certificate.NotBefore
+	}
+	cert.NotAfter = subject.Certificate.NotAfter
+	if issuer.Certificate.NotAfter.Before(cert.NotAfter) {
+		cert.NotAfter = issuer.Certificate.NotAfter
+	}
+	cert.MaxPathLen = maxPathLen
+	cert.MaxPathLenZero = maxPathLen == 0
+	constraints := subject.NameConstraints()
+	constraints.apply(cert)
+	err = dp.apply(cert)
+	if err != nil {
+		return
+	}
+	cert.SubjectKeyId = subject.Certificate.SubjectKeyId
+	cert.AuthorityKeyId = issuer.Certificate.SubjectKeyId
+	cert.SignatureAlgorithm, err = issuer.x509SignatureAlgorithm()
+	if err != nil {
+		return
+	}
+
+	err = checkExtensions(cert.ExtraExtensions)
+	if err != nil {
+		return
+	}
+
+	raw, err := x509.CreateCertificate(rand.Reader, cert, issuer.Certificate, pub, issuerKey)
+	if err != nil {
+		return
+	}
+
+	p, err = newParcel(visible, id, raw, nil)
+	if err != nil {
+		return
+	}
+	p.Settings = &CASettings{}
+	return
+}
@@ -0,0 +1,46 @@
+package liftca
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+)
+
+// SerialPolicy names how a CA picks the serial numbers of the certificates
+// it issues.  The empty SerialPolicy is SerialRandom.  Either way, serial
+// numbers are unique among the certificates of the CA.
+type SerialPolicy string
+
+const (
+	// SerialRandom draws serial numbers of up to 159 bits from
+	// crypto/rand, well above the 64 bits of entropy the CA/Browser Forum
+	// requires, while fitting the 20 octets RFC 5280 allows.
+	SerialRandom SerialPolicy = "random"
+	// SerialSequential numbers certificates 1, 2, 3 and so on, with a
+	// counter kept per CA.  Root CA certificates are self-issued, and
+	// always get a random serial number.
+	SerialSequential SerialPolicy = "sequential"
+)
+
+var maxRandomSerial = new(big.Int).Lsh(big.NewInt(1), 159)
+
+func (p SerialPolicy) validate() error {
+	switch p {
+	case "", SerialRandom, SerialSequential:
+		return nil
+	}
+	return fmt.Errorf("unknown serial number policy '%v'", p)
+}
+
+// randomSerial returns a positive random serial number of up to 159 bits.
+func randomSerial() (*big.Int, error) {
+	for {
+		serial, err := rand.Int(rand.Reader, maxRandomSerial)
+		if err != nil {
+			return nil, err
+		}
+		if serial.Sign() > 0 {
+			return serial, nil
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package liftca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+)
+
+// SignatureAlgorithm names the hash, and for RSA keys the padding, a CA
+// signs certificates and CRLs with.  The empty SignatureAlgorithm picks a
+// hash matching the strength of the key.  Ed25519 keys have a single
+// algorithm, and accept only the empty SignatureAlgorithm.
+type SignatureAlgorithm string
+
+const (
+	SignatureSHA256       SignatureAlgorithm = "sha256"
+	SignatureSHA384       SignatureAlgorithm = "sha384"
+	SignatureSHA512       SignatureAlgorithm = "sha512"
+	SignatureRSAPSSSHA256 SignatureAlgorithm = "rsa-pss-sha256"
+	SignatureRSAPSSSHA384 SignatureAlgorithm = "rsa-pss-sha384"
+	SignatureRSAPSSSHA512 SignatureAlgorithm = "rsa-pss-sha512"
+)
+
+var rsaSignatureAlgorithms = map[SignatureAlgorithm]x509.SignatureAlgorithm{
+	SignatureSHA256:       x509.SHA256WithRSA,
+	SignatureSHA384:       x509.SHA384WithRSA,
+	SignatureSHA512:       x509.SHA512WithRSA,
+	SignatureRSAPSSSHA256: x509.SHA256WithRSAPSS,
+	SignatureRSAPSSSHA384: x509.SHA384WithRSAPSS,
+	SignatureRSAPSSSHA512: x509.SHA512WithRSAPSS,
+}
+
+var ecdsaSignatureAlgorithms = map[SignatureAlgorithm]x509.SignatureAlgorithm{
+	SignatureSHA256: x509.ECDSAWithSHA256,
+	SignatureSHA384: x509.ECDSAWithSHA384,
+	SignatureSHA512: x509.ECDSAWithSHA512,
+}
+
+// KeyIDMethod names how the subject key identifiers of the certificates a
+// CA issues are computed from their public key.  The empty KeyIDMethod is
+// KeyIDSHA1.
+type KeyIDMethod string
+
+const (
+	// KeyIDSHA1 is the SHA-1 hash of the subjectPublicKey bit string
+	// (RFC 5280, section 4.2.1.2, method 1).
+	KeyIDSHA1 KeyIDMethod = "sha1"
+	// KeyIDSHA256, KeyIDSHA384 and KeyIDSHA512 are the leftmost 160 bits
+	// of the SHA-256, SHA-384 or SHA-512 hash of the subjectPublicKey bit
+	// string (RFC 7093, section 2, methods 1 to 3).
+	KeyIDSHA256 KeyIDMethod = "sha256"
+	KeyIDSHA384 KeyIDMethod = "sha384"
+	KeyIDSHA512 KeyIDMethod = "sha512"
+	// KeyIDSPKISHA256 is the SHA-256 hash of the whole DER encoded
+	// SubjectPublicKeyInfo (RFC 7093, section 2, method 4).
+	KeyIDSPKISHA256 KeyIDMethod = "spki-sha256"
+)
+
+// x509SignatureAlgorithm returns the algorithm a key whose public half is
+// pub signs with, following alg.
+func x509SignatureAlgorithm(pub crypto.PublicKey, alg SignatureAlgorithm) (x509.SignatureAlgorithm, error) {
+	if alg == "" {
+		return signatureAlgorithmFor(pub), nil
+	}
+	var algorithms map[SignatureAlgorithm]x509.SignatureAlgorithm
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		algorithms = rsaSignatureAlgorithms
+	case *ecdsa.PublicKey:
+		algorithms = ecdsaSignatureAlgorithms
+	case ed25519.PublicKey:
+		return 0, fmt.Errorf("Ed25519 keys have a single signature algorithm; '%v' cannot be chosen", alg)
+	}
+	sigAlg, found := algorithms[alg]
+	if !found {
+		return 0, fmt.Errorf("unknown signature algorithm '%v' for %v keys", alg, keyTypeOf(pub))
+	}
+	return sigAlg, nil
+}
+
+// subjectKeyID computes the key identifier of pub with method.
+func subjectKeyID(pub crypto.PublicKey, method KeyIDMethod) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	if method == KeyIDSPKISHA256 {
+		h := sha256.Sum256(der)
+		return h[:], nil
+	}
+	var spki struct {
+		Algorithm        pkix.AlgorithmIdentifier
+		SubjectPublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(der, &spki); err != nil {
+		return nil, err
+	}
+	key := spki.SubjectPublicKey.Bytes
+	switch method {
+	case "", KeyIDSHA1:
+		h := sha1.Sum(key)
+		return h[:], nil
+	case KeyIDSHA256:
+		h := sha256.Sum256(key)
+		return h[:20], nil
+	case KeyIDSHA384:
+		h := sha512.Sum384(key)
+		return h[:20], nil
+	case KeyIDSHA512:
+		h := sha512.Sum512(key)
+		return h[:20], nil
+	}
+	return nil, fmt.Errorf("unknown key identifier method '%v'", method)
+}
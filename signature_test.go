@@ -0,0 +1,58 @@
+package liftca
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+)
+
+func TestDefaultSignatureAlgorithms(t *testing.T) {
+	want := map[KeyType]x509.SignatureAlgorithm{
+		KeyTypeRSA2048:   x509.SHA256WithRSA,
+		KeyTypeRSA3072:   x509.SHA384WithRSA,
+		KeyTypeRSA4096:   x509.SHA512WithRSA,
+		KeyTypeECDSAP256: x509.ECDSAWithSHA256,
+		KeyTypeECDSAP384: x509.ECDSAWithSHA384,
+		KeyTypeEd25519:   x509.PureEd25519,
+	}
+	for kt, alg := range want {
+		s := NewStore()
+		ca, err := s.AddCA(true, &CARequest{Subject: pkix.Name{CommonName: "root"}, KeyType: kt})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := getTestParcel(t, s, ca).Certificate.SignatureAlgorithm; got != alg {
+			t.Errorf("%v CA signs with %v, want %v", kt, got, alg)
+		}
+	}
+}
+
+func TestSignatureAlgorithmChoice(t *testing.T) {
+	s := NewStore()
+	ca, err := s.AddCA(true, &CARequest{
+		Subject:  pkix.Name{CommonName: "root"},
+		KeyType:  KeyTypeECDSAP256,
+		Settings: CASettings{SignatureAlgorithm: SignatureSHA384},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := getTestParcel(t, s, addTestCert(t, s, ca, "www.lab"))
+	if leaf.Certificate.SignatureAlgorithm != x509.ECDSAWithSHA384 {
+		t.Errorf("certificate signed with %v", leaf.Certificate.SignatureAlgorithm)
+	}
+	if _, err := s.AddCA(true, &CARequest{
+		Subject:  pkix.Name{CommonName: "ed"},
+		KeyType:  KeyTypeEd25519,
+		Settings: CASettings{SignatureAlgorithm: SignatureSHA256},
+	}); err == nil {
+		t.Error("signature algorithm chosen for an Ed25519 CA")
+	}
+	if _, err := s.AddCA(true, &CARequest{
+		Subject:  pkix.Name{CommonName: "pss"},
+		KeyType:  KeyTypeECDSAP256,
+		Settings: CASettings{SignatureAlgorithm: SignatureRSAPSSSHA256},
+	}); err == nil {
+		t.Error("RSA-PSS chosen for an ECDSA CA")
+	}
+}
@@ -1,24 +1,57 @@
 package liftca
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/gob"
 	"fmt"
 	"io"
 	"log"
+	"math/big"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/jeanfric/liftca/idsource"
+	"golang.org/x/crypto/ocsp"
 )
 
 type Store struct {
-	rw        sync.RWMutex
-	idsource  *idsource.IDSource
-	m         map[int64]*Parcel
-	parent    map[int64]int64
-	children  map[int64][]int64
-	topLevel  map[int64]bool
-	revoked   map[int64]bool
+	rw       sync.RWMutex
+	idsource *idsource.IDSource
+	m        map[int64]*Parcel
+	parent   map[int64]int64
+	children map[int64][]int64
+	topLevel map[int64]bool
+	// revoked holds the revocation records of revoked certificates.
+	revoked map[int64]Revocation
+	// crls and deltaCRLs hold the last CRL, and delta CRL, issued by each
+	// CA.
+	crls      map[int64]*issuedCRL
+	deltaCRLs map[int64]*issuedCRL
+	// revocationLog holds, for each CA, the changes to the revocations of
+	// its certificates, oldest first; delta CRLs are built from it.
+	revocationLog map[int64][]revocationEvent
+	// predecessor and successors link renewed certificates, and rolled
+	// over CAs, to those that replace them.
+	predecessor map[int64]int64
+	successors  map[int64][]int64
+	// crossOf maps cross certificates to the CA whose key they certify.
+	crossOf map[int64]int64
+	// lastSerials holds the last serial number of CAs with the sequential
+	// serial policy.
+	lastSerials map[int64]*big.Int
+	// ocspResponders maps CAs to their current delegated OCSP responder.
+	ocspResponders map[int64]int64
+	// ocspMutex keeps concurrent OCSP requests from each issuing a new
+	// delegated responder.
+	ocspMutex sync.Mutex
 	listeners []chan<- struct{}
+	// distribution returns the distribution points of a CA; it is not
+	// persisted, as it depends on where liftCA is served from.
+	distribution func(caSerial int64) DistributionPoints
 }
 
 type gobStore struct {
@@ -27,13 +60,45 @@ type gobStore struct {
 	Parent   map[int64]int64
 	Children map[int64][]int64
 	TopLevel map[int64]bool
-	Revoked  map[int64]bool
+	// Revoked is only read, from stores saved by earlier versions, which
+	// did not keep revocation records.
+	Revoked        map[int64]bool
+	Revocations    map[int64]Revocation
+	CRLs           map[int64]*issuedCRL
+	DeltaCRLs      map[int64]*issuedCRL
+	RevocationLog  map[int64][]revocationEvent
+	Predecessor    map[int64]int64
+	Successors     map[int64][]int64
+	CrossOf        map[int64]int64
+	LastSerials    map[int64]*big.Int
+	OCSPResponders map[int64]int64
 }
 
 func (s *Store) Updates(c chan<- struct{}) {
 	s.listeners = append(s.listeners, c)
 }
 
+// SetDistribution makes the certificates issued from now on embed the
+// distribution points that f returns for their issuing CA.
+func (s *Store) SetDistribution(f func(caSerial int64) DistributionPoints) {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+	s.distribution = f
+}
+
+func (s *Store) distributionPoints(caSerial int64) DistributionPoints {
+	var dp DistributionPoints
+	s.withRLocked(func() {
+		if s.distribution != nil {
+			dp = s.distribution(caSerial)
+			if ca, found := s.m[caSerial]; !found || ca.DeltaCRLTTL() == 0 {
+				dp.DeltaCRLURL = ""
+			}
+		}
+	})
+	return dp
+}
+
 func (s *Store) signalUpdates() {
 	for _, c := range s.listeners {
 		c <- struct{}{}
@@ -42,14 +107,22 @@ func (s *Store) signalUpdates() {
 
 func NewStore() *Store {
 	s := &Store{
-		rw:        sync.RWMutex{},
-		idsource:  idsource.New(make([]int64, 0)),
-		m:         make(map[int64]*Parcel),
-		children:  make(map[int64][]int64),
-		parent:    make(map[int64]int64),
-		topLevel:  make(map[int64]bool),
-		revoked:   make(map[int64]bool),
-		listeners: make([]chan<- struct{}, 0),
+		rw:             sync.RWMutex{},
+		idsource:       idsource.New(make([]int64, 0)),
+		m:              make(map[int64]*Parcel),
+		children:       make(map[int64][]int64),
+		parent:         make(map[int64]int64),
+		topLevel:       make(map[int64]bool),
+		revoked:        make(map[int64]Revocation),
+		crls:           make(map[int64]*issuedCRL),
+		deltaCRLs:      make(map[int64]*issuedCRL),
+		revocationLog:  make(map[int64][]revocationEvent),
+		predecessor:    make(map[int64]int64),
+		successors:     make(map[int64][]int64),
+		crossOf:        make(map[int64]int64),
+		lastSerials:    make(map[int64]*big.Int),
+		ocspResponders: make(map[int64]int64),
+		listeners:      make([]chan<- struct{}, 0),
 	}
 	return s
 }
@@ -65,30 +138,101 @@ func LoadStore(source io.Reader) *Store {
 		d.Children = make(map[int64][]int64)
 		d.Parent = make(map[int64]int64)
 		d.TopLevel = make(map[int64]bool)
-		d.Revoked = make(map[int64]bool)
+	}
+	// Stores saved before revocation records existed only tell which
+	// certificates are revoked; they are dated from when they are upgraded.
+	if d.Revocations == nil {
+		d.Revocations = make(map[int64]Revocation)
+		now := time.Now().UTC().Truncate(time.Second)
+		for id, revoked := range d.Revoked {
+			if revoked {
+				d.Revocations[id] = Revocation{Time: now}
+			}
+		}
+	}
+	if d.CRLs == nil {
+		d.CRLs = make(map[int64]*issuedCRL)
+	}
+	if d.DeltaCRLs == nil {
+		d.DeltaCRLs = make(map[int64]*issuedCRL)
+	}
+	// Stores saved before the revocation log existed get one made of their
+	// current revocations.
+	if d.RevocationLog == nil {
+		d.RevocationLog = make(map[int64][]revocationEvent)
+		ids := make([]int64, 0, len(d.Revocations))
+		for id := range d.Revocations {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool {
+			return d.Revocations[ids[i]].Time.Before(d.Revocations[ids[j]].Time)
+		})
+		for _, id := range ids {
+			ca := d.Parent[id]
+			d.RevocationLog[ca] = append(d.RevocationLog[ca], revocationEvent{ID: id, Revocation: d.Revocations[id]})
+		}
+	}
+	// Stores saved before renewals existed have no lineage.
+	if d.Predecessor == nil {
+		d.Predecessor = make(map[int64]int64)
+		d.Successors = make(map[int64][]int64)
+	}
+	if d.CrossOf == nil {
+		d.CrossOf = make(map[int64]int64)
+	}
+	if d.LastSerials == nil {
+		d.LastSerials = make(map[int64]*big.Int)
+	}
+	if d.OCSPResponders == nil {
+		d.OCSPResponders = make(map[int64]int64)
+	}
+	for id, p := range d.M {
+		if err := p.upgrade(id); err != nil {
+			log.Printf("unable to upgrade %v: %v", id, err)
+		}
 	}
 	s := &Store{
-		rw:        sync.RWMutex{},
-		idsource:  idsource.New(d.SpentIDs),
-		m:         d.M,
-		children:  d.Children,
-		parent:    d.Parent,
-		topLevel:  d.TopLevel,
-		revoked:   d.Revoked,
-		listeners: make([]chan<- struct{}, 0),
+		rw:             sync.RWMutex{},
+		idsource:       idsource.New(d.SpentIDs),
+		m:              d.M,
+		children:       d.Children,
+		parent:         d.Parent,
+		topLevel:       d.TopLevel,
+		revoked:        d.Revocations,
+		crls:           d.CRLs,
+		deltaCRLs:      d.DeltaCRLs,
+		revocationLog:  d.RevocationLog,
+		predecessor:    d.Predecessor,
+		successors:     d.Successors,
+		crossOf:        d.CrossOf,
+		lastSerials:    d.LastSerials,
+		ocspResponders: d.OCSPResponders,
+		listeners:      make([]chan<- struct{}, 0),
 	}
 	return s
 }
 
 func (s *Store) DumpStore(dest io.Writer) {
 	s.withRLocked(func() {
+		m := make(map[int64]*Parcel, len(s.m))
+		for id, p := range s.m {
+			m[id] = p.forStorage()
+		}
 		d := gobStore{
-			SpentIDs: s.idsource.SpentIDs(),
-			M:        s.m,
-			Parent:   s.parent,
-			Children: s.children,
-			TopLevel: s.topLevel,
-			Revoked:  s.revoked,
+			SpentIDs:       s.idsource.SpentIDs(),
+			M:              m,
+			Parent:         s.parent,
+			Children:       s.children,
+			TopLevel:       s.topLevel,
+			Revocations:    s.revoked,
+			CRLs:           s.crls,
+			DeltaCRLs:      s.deltaCRLs,
+			RevocationLog:  s.revocationLog,
+			Predecessor:    s.predecessor,
+			Successors:     s.successors,
+			CrossOf:        s.crossOf,
+			LastSerials:    s.lastSerials,
+			OCSPResponders: s.ocspResponders,
 		}
 		enc := gob.NewEncoder(dest)
 		err := enc.Encode(d)
@@ -101,67 +245,595 @@ func (s *Store) DumpStore(dest io.Writer) {
 func (s *Store) IsRevoked(id int64) bool {
 	var revoked bool
 	s.withRLocked(func() {
-		revoked = s.revoked[id]
+		_, revoked = s.revoked[id]
 	})
 	return revoked
 }
 
-func (s *Store) SetRevoked(id int64, revoked bool) {
+// GetRevocation returns the revocation record of the certificate id, if it
+// is revoked.
+func (s *Store) GetRevocation(id int64) (Revocation, bool) {
+	var r Revocation
+	var found bool
+	s.withRLocked(func() {
+		r, found = s.revoked[id]
+	})
+	return r, found
+}
+
+// Revoke revokes the certificate id as r says; r.Time defaults to now.  A
+// certificate that is already revoked may only be revoked again while it is
+// on hold.
+func (s *Store) Revoke(id int64, r Revocation) error {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	r.Time = r.Time.UTC().Truncate(time.Second)
+	r.InvalidityDate = r.InvalidityDate.UTC().Truncate(time.Second)
+	if err := r.validate(); err != nil {
+		return err
+	}
+	var err error
 	s.withLocked(func() {
-		s.revoked[id] = revoked
+		if old, found := s.revoked[id]; found && old.Reason != ReasonCertificateHold {
+			err = fmt.Errorf("certificate %v is already revoked", id)
+			return
+		}
+		s.revoked[id] = r
+		s.revocationsChanged(id, r)
 	})
-	return
+	return err
 }
 
-func (s *Store) AddCA(visible bool, name string) (int64, error) {
-	serial := s.idsource.Int63()
-	p, err := makeCAParcel(visible, serial, name)
+// Unrevoke cancels the revocation of the certificate id.
+func (s *Store) Unrevoke(id int64) {
+	s.withLocked(func() {
+		if _, found := s.revoked[id]; !found {
+			return
+		}
+		delete(s.revoked, id)
+		s.revocationsChanged(id, Revocation{
+			Time:   time.Now().UTC().Truncate(time.Second),
+			Reason: ReasonRemoveFromCRL,
+		})
+	})
+}
+
+// revocationsChanged logs the change r to the revocation of the
+// certificate id, and marks the CRLs of its issuer that no longer list the
+// current revocations as outdated: its last delta CRL if it publishes them,
+// or else its last CRL.  The store must be locked.
+func (s *Store) revocationsChanged(id int64, r Revocation) {
+	ca := s.parent[id]
+	s.revocationLog[ca] = append(s.revocationLog[ca], revocationEvent{ID: id, Revocation: r})
+	if c, found := s.deltaCRLs[ca]; found {
+		c.DER = nil
+	}
+	if p, found := s.m[ca]; found && p.DeltaCRLTTL() != 0 {
+		return
+	}
+	if c, found := s.crls[ca]; found {
+		c.DER = nil
+	}
+}
+
+func (s *Store) AddCA(visible bool, req *CARequest) (int64, error) {
+	id := s.idsource.Int63()
+	serial, err := randomSerial()
+	if err != nil {
+		return 0, err
+	}
+	p, err := makeCAParcel(visible, id, serial, req, nil, DistributionPoints{})
 	if err != nil {
 		return 0, err
 	}
 	s.withLocked(func() {
-		s.m[serial] = p
-		s.topLevel[serial] = true
-		s.children[serial] = make([]int64, 0)
+		s.m[id] = p
+		s.topLevel[id] = true
+		s.children[id] = make([]int64, 0)
 	})
-	return serial, nil
+	return id, nil
+}
+
+// AddSubCA creates an intermediate CA issued by the CA parentId.
+func (s *Store) AddSubCA(visible bool, parentId int64, req *CARequest) (int64, error) {
+	id := s.idsource.Int63()
+	parent, found := s.Get(parentId)
+	if !found || !parent.Certificate.IsCA {
+		return 0, fmt.Errorf("parent CA not found")
+	}
+	serial, err := s.nextSerial(parent)
+	if err != nil {
+		return 0, err
+	}
+
+	p, err := makeCAParcel(visible, id, serial, req, parent, s.distributionPoints(parentId))
+	if err != nil {
+		return 0, err
+	}
+
+	s.withLocked(func() {
+		if err = s.checkSerial(p); err != nil {
+			return
+		}
+		s.m[id] = p
+		s.parent[id] = parentId
+		s.children[parentId] = append(s.children[parentId], id)
+		s.children[id] = make([]int64, 0)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
 }
 
-func (s *Store) AddExistingCA(visible bool, pemCertificate []byte, pemPrivateKey []byte, pemPassword []byte) (int64, error) {
-	serial := s.idsource.Int63()
-	p, err := importCAFromPEM(visible, serial, pemCertificate, pemPrivateKey, pemPassword)
+// RolloverCA creates the next generation of the root CA id, with a new key,
+// along with the cross certificates that let clients trusting either root
+// validate certificates issued by the other.  The new root records that it
+// replaces the old one.
+func (s *Store) RolloverCA(id int64, req *RolloverRequest) (Rollover, error) {
+	var r Rollover
+	old, found := s.Get(id)
+	if !found || !old.Certificate.IsCA {
+		return r, fmt.Errorf("CA not found")
+	}
+	caReq, err := rolloverCARequest(old, req)
+	if err != nil {
+		return r, err
+	}
+	r.NewCA = s.idsource.Int63()
+	serial, err := randomSerial()
+	if err != nil {
+		return r, err
+	}
+	ca, err := makeCAParcel(old.Visible, r.NewCA, serial, caReq, nil, DistributionPoints{})
+	if err != nil {
+		return r, err
+	}
+	r.NewSignedByOld = s.idsource.Int63()
+	serial, err = s.nextSerial(old)
+	if err != nil {
+		return r, err
+	}
+	newSignedByOld, err := makeCrossParcel(old.Visible, r.NewSignedByOld, serial, old, ca, s.distributionPoints(old.ID))
+	if err != nil {
+		return r, err
+	}
+	// Both roots have the same name, hence the same serial numbers; the
+	// first cross certificate is not in the store yet, so the second must
+	// avoid its serial number explicitly.
+	r.OldSignedByNew = s.idsource.Int63()
+	for serial = newSignedByOld.SerialNumber(); serial.Cmp(newSignedByOld.SerialNumber()) == 0; {
+		if serial, err = s.nextSerial(ca); err != nil {
+			return r, err
+		}
+	}
+	oldSignedByNew, err := makeCrossParcel(old.Visible, r.OldSignedByNew, serial, ca, old, s.distributionPoints(r.NewCA))
+	if err != nil {
+		return r, err
+	}
+
+	s.withLocked(func() {
+		if err = s.checkSerial(newSignedByOld); err != nil {
+			return
+		}
+		if err = s.checkSerial(oldSignedByNew); err != nil {
+			return
+		}
+		s.m[r.NewCA] = ca
+		s.topLevel[r.NewCA] = true
+		s.children[r.NewCA] = make([]int64, 0)
+		s.addCross(newSignedByOld, id, r.NewCA)
+		s.addCross(oldSignedByNew, r.NewCA, id)
+		s.predecessor[r.NewCA] = id
+		s.successors[id] = append(s.successors[id], r.NewCA)
+	})
+	if err != nil {
+		return Rollover{}, err
+	}
+	return r, nil
+}
+
+// CrossSign has the CA issuerId issue a cross certificate for the subject
+// and key of the CA subjectId, giving the certificates of subjectId a path
+// to the root of issuerId.
+func (s *Store) CrossSign(issuerId, subjectId int64) (int64, error) {
+	id := s.idsource.Int63()
+	issuer, found := s.Get(issuerId)
+	if !found || !issuer.Certificate.IsCA {
+		return 0, fmt.Errorf("issuing CA not found")
+	}
+	subject, found := s.Get(subjectId)
+	if !found || !subject.Certificate.IsCA {
+		return 0, fmt.Errorf("CA to cross-sign not found")
+	}
+	if bytes.Equal(issuer.Certificate.RawSubjectPublicKeyInfo, subject.Certificate.RawSubjectPublicKeyInfo) {
+		return 0, fmt.Errorf("a CA cannot cross-sign its own key")
+	}
+	serial, err := s.nextSerial(issuer)
+	if err != nil {
+		return 0, err
+	}
+
+	p, err := makeCrossParcel(subject.Visible, id, serial, issuer, subject, s.distributionPoints(issuerId))
 	if err != nil {
 		return 0, err
 	}
 
 	s.withLocked(func() {
-		s.m[serial] = p
-		s.topLevel[serial] = true
-		s.children[serial] = make([]int64, 0)
+		if err = s.checkSerial(p); err != nil {
+			return
+		}
+		s.addCross(p, issuerId, subjectId)
 	})
-	return serial, nil
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
 }
 
-func (s *Store) Add(visible bool, parentId int64, host string) (int64, error) {
-	serial := s.idsource.Int63()
-	parent, found := s.Get(parentId)
+// addCross adds the cross certificate p, issued by the CA issuerId for the
+// key of the CA subjectId.  The store must be locked.
+func (s *Store) addCross(p *Parcel, issuerId, subjectId int64) {
+	s.m[p.ID] = p
+	s.parent[p.ID] = issuerId
+	s.children[issuerId] = append(s.children[issuerId], p.ID)
+	s.children[p.ID] = make([]int64, 0)
+	s.crossOf[p.ID] = subjectId
+}
+
+// ExistingCAError is returned when importing a CA that is already in the
+// store with its private key.
+type ExistingCAError struct {
+	ID int64
+}
+
+func (e *ExistingCAError) Error() string {
+	return fmt.Sprintf("CA %v is already in the store", e.ID)
+}
+
+// AddExistingCA imports a CA certificate and its private key, PEM encoded.
+// pemChain optionally holds the certificates of its issuers, from its own
+// issuer up to the root.
+func (s *Store) AddExistingCA(visible bool, pemCertificate []byte, pemPrivateKey []byte, pemPassword []byte, pemChain []byte, settings CASettings) (int64, error) {
+	p, err := importCAFromPEM(visible, s.idsource.Int63(), pemCertificate, pemPrivateKey, pemPassword, settings)
+	if err != nil {
+		return 0, err
+	}
+	chain, err := parsePEMCertificates(pemChain)
+	if err != nil {
+		return 0, err
+	}
+	return s.addImportedCA(p, chain)
+}
+
+// AddExistingCAFromPKCS12 imports the CA certificate and private key of a
+// PKCS#12 bundle, along with the CA's issuers found in the bundle.
+func (s *Store) AddExistingCAFromPKCS12(visible bool, pfx []byte, password []byte, settings CASettings) (int64, error) {
+	p, chain, err := importCAFromPKCS12(visible, s.idsource.Int63(), pfx, password, settings)
+	if err != nil {
+		return 0, err
+	}
+	return s.addImportedCA(p, chain)
+}
+
+// addImportedCA adds the imported CA p under its issuers, given in chain
+// from its own issuer up; those not already in the store are added without
+// a private key.  A CA already in the store without a private key receives
+// that of p instead.
+func (s *Store) addImportedCA(p *Parcel, chain []*x509.Certificate) (int64, error) {
+	if err := checkChain(p.Certificate, chain); err != nil {
+		return 0, err
+	}
+	parents := make([]*Parcel, len(chain))
+	for i, cert := range chain {
+		var err error
+		parents[i], err = newParcel(p.Visible, s.idsource.Int63(), cert.Raw, nil)
+		if err != nil {
+			return 0, err
+		}
+		parents[i].Settings = &CASettings{}
+	}
+
+	id := p.ID
+	var err error
+	s.withLocked(func() {
+		if existing, found := s.findByDER(p.DERCertificateBytes); found {
+			if existing.HasPrivateKey() {
+				err = &ExistingCAError{ID: existing.ID}
+				return
+			}
+			withKey := *existing
+			withKey.PKCS8PrivateKey = p.PKCS8PrivateKey
+			withKey.Settings = p.Settings
+			s.m[existing.ID] = &withKey
+			id = existing.ID
+			return
+		}
+		s.m[p.ID] = p
+		s.children[p.ID] = make([]int64, 0)
+		child := p.ID
+		for _, parent := range parents {
+			if existing, found := s.findByDER(parent.DERCertificateBytes); found {
+				s.parent[child] = existing.ID
+				s.children[existing.ID] = append(s.children[existing.ID], child)
+				return
+			}
+			s.m[parent.ID] = parent
+			s.parent[child] = parent.ID
+			s.children[parent.ID] = []int64{child}
+			child = parent.ID
+		}
+		s.topLevel[child] = true
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// ImportedCert is the outcome of importing one certificate with
+// AddExistingCerts: the id it was stored under and the id of the CA that
+// issued it, or why it was not imported.
+type ImportedCert struct {
+	Certificate *x509.Certificate
+	ID          int64
+	ParentID    int64
+	Err         error
+}
+
+// AddExistingCerts imports PEM encoded leaf certificates, each under the CA
+// of the store that issued it.  Only visible CAs, and the CAs caIDs, are
+// considered: knowing its id is what grants access to an invisible CA.
+// pemPrivateKeys optionally holds private keys, each stored with the
+// certificate it matches.  A certificate that cannot be imported does not
+// prevent the others from being imported; the reason is reported in its
+// ImportedCert.
+func (s *Store) AddExistingCerts(visible bool, pemCertificates []byte, pemPrivateKeys []byte, pemPassword []byte, caIDs []int64) ([]ImportedCert, error) {
+	certs, err := parsePEMCertificates(pemCertificates)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("No certificate found")
+	}
+	keys, err := parsePEMPrivateKeys(pemPrivateKeys, pemPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	imported := make([]ImportedCert, len(certs))
+	s.withLocked(func() {
+		for i, cert := range certs {
+			imported[i].Certificate = cert
+			imported[i].ID, imported[i].ParentID, imported[i].Err = s.addExistingCert(visible, cert, keys, caIDs)
+		}
+	})
+	return imported, nil
+}
+
+// addExistingCert adds the leaf certificate cert under the visible CA, or
+// CA of caIDs, that issued it, along with its private key if keys holds it.
+// The store must be locked.
+func (s *Store) addExistingCert(visible bool, cert *x509.Certificate, keys []crypto.Signer, caIDs []int64) (int64, int64, error) {
+	if cert.IsCA {
+		return 0, 0, fmt.Errorf("Certificate is a CA certificate; import it as a CA")
+	}
+	if _, found := s.findByDER(cert.Raw); found {
+		return 0, 0, fmt.Errorf("Certificate is already in the store")
+	}
+	parent, found := s.findIssuer(cert, caIDs)
 	if !found {
-		return 0, fmt.Errorf("parent not found")
+		return 0, 0, fmt.Errorf("No CA in the store issued the certificate")
+	}
+	var key crypto.Signer
+	for _, k := range keys {
+		if pub, ok := k.Public().(interface{ Equal(crypto.PublicKey) bool }); ok && pub.Equal(cert.PublicKey) {
+			key = k
+			break
+		}
+	}
+	p, err := newParcel(visible, s.idsource.Int63(), cert.Raw, key)
+	if err != nil {
+		return 0, 0, err
+	}
+	s.m[p.ID] = p
+	s.parent[p.ID] = parent.ID
+	s.children[parent.ID] = append(s.children[parent.ID], p.ID)
+	return p.ID, parent.ID, nil
+}
+
+// findIssuer returns the CA that issued cert among the visible CAs and the
+// CAs caIDs, preferring one with a private key, then the lowest id so the
+// choice does not vary.  The store must be locked.
+func (s *Store) findIssuer(cert *x509.Certificate, caIDs []int64) (*Parcel, bool) {
+	named := make(map[int64]bool, len(caIDs))
+	for _, id := range caIDs {
+		named[id] = true
+	}
+	var issuer *Parcel
+	for _, p := range s.m {
+		if !p.Visible && !named[p.ID] || !p.isIssuerOf(cert) {
+			continue
+		}
+		if issuer == nil || (p.HasPrivateKey() && !issuer.HasPrivateKey()) ||
+			(p.HasPrivateKey() == issuer.HasPrivateKey() && p.ID < issuer.ID) {
+			issuer = p
+		}
+	}
+	return issuer, issuer != nil
+}
+
+// findByDER returns the parcel whose certificate is der.  The store must be
+// locked.
+func (s *Store) findByDER(der []byte) (*Parcel, bool) {
+	for _, p := range s.m {
+		if bytes.Equal(p.DERCertificateBytes, der) {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+func (s *Store) Add(visible bool, parentId int64, req *CertRequest) (int64, error) {
+	id := s.idsource.Int63()
+	issuers, found := s.GetChain(parentId)
+	if !found || !issuers[0].Certificate.IsCA {
+		return 0, fmt.Errorf("parent CA not found")
+	}
+	serial, err := s.nextSerial(issuers[0])
+	if err != nil {
+		return 0, err
+	}
+
+	p, err := makeParcel(visible, id, serial, issuers, s.distributionPoints(parentId), req)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, s.addIssued(p, parentId)
+}
+
+// AddFromCSR has the CA parentId sign a PEM or DER encoded PKCS#10 request.
+// The new parcel has no private key.
+func (s *Store) AddFromCSR(visible bool, parentId int64, csr []byte, req *CertRequest) (int64, error) {
+	id := s.idsource.Int63()
+	issuers, found := s.GetChain(parentId)
+	if !found || !issuers[0].Certificate.IsCA {
+		return 0, fmt.Errorf("parent CA not found")
+	}
+	serial, err := s.nextSerial(issuers[0])
+	if err != nil {
+		return 0, err
+	}
+
+	p, err := makeParcelFromCSR(visible, id, serial, issuers, s.distributionPoints(parentId), csr, req)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, s.addIssued(p, parentId)
+}
+
+// Renew has the CA that issued the certificate id issue its successor, and
+// records that the successor replaces it.
+func (s *Store) Renew(id int64, req *RenewRequest) (int64, error) {
+	newId := s.idsource.Int63()
+	old, found := s.Get(id)
+	if !found {
+		return 0, fmt.Errorf("certificate not found")
+	}
+	parentId, found := s.GetParent(id)
+	if !found {
+		return 0, fmt.Errorf("parent CA not found")
+	}
+	issuers, found := s.GetChain(parentId)
+	if !found {
+		return 0, fmt.Errorf("parent CA not found")
+	}
+	serial, err := s.nextSerial(issuers[0])
+	if err != nil {
+		return 0, err
+	}
+
+	p, err := makeRenewedParcel(old.Visible, newId, serial, issuers, s.distributionPoints(parentId), old, req)
+	if err != nil {
+		return 0, err
 	}
 
-	p, err := makeParcel(visible, serial, parent, host)
+	s.withLocked(func() {
+		if err = s.insertIssued(p, parentId); err != nil {
+			return
+		}
+		s.predecessor[newId] = id
+		s.successors[id] = append(s.successors[id], newId)
+	})
 	if err != nil {
 		return 0, err
 	}
+	return newId, nil
+}
 
+// addIssued adds the leaf certificate p, issued by the CA parentId, unless
+// that CA already issued a certificate with the same serial number.
+func (s *Store) addIssued(p *Parcel, parentId int64) error {
+	var err error
+	s.withLocked(func() {
+		err = s.insertIssued(p, parentId)
+	})
+	return err
+}
+
+// insertIssued is addIssued for callers that hold the lock.  The store must
+// be locked.
+func (s *Store) insertIssued(p *Parcel, parentId int64) error {
+	if err := s.checkSerial(p); err != nil {
+		return err
+	}
+	s.m[p.ID] = p
+	s.parent[p.ID] = parentId
+	s.children[parentId] = append(s.children[parentId], p.ID)
+	return nil
+}
+
+// nextSerial picks the serial number of the next certificate issued by the
+// CA ca, following its serial policy.  Sequential serial numbers are
+// reserved as they are picked; checkSerial checks again that the serial
+// number is unused when the certificate is added.
+func (s *Store) nextSerial(ca *Parcel) (*big.Int, error) {
+	if ca.SerialPolicy() != SerialSequential {
+		for {
+			serial, err := randomSerial()
+			if err != nil {
+				return nil, err
+			}
+			var taken bool
+			s.withRLocked(func() {
+				taken = s.serialTaken(ca.Certificate.RawSubject, serial)
+			})
+			if !taken {
+				return serial, nil
+			}
+		}
+	}
+	serial := new(big.Int)
 	s.withLocked(func() {
-		s.m[serial] = p
-		s.parent[serial] = parentId
-		s.children[parentId] = append(s.children[parentId], serial)
+		if last, found := s.lastSerials[ca.ID]; found {
+			serial.Set(last)
+		}
+		for {
+			serial.Add(serial, big.NewInt(1))
+			if !s.serialTaken(ca.Certificate.RawSubject, serial) {
+				break
+			}
+		}
+		s.lastSerials[ca.ID] = new(big.Int).Set(serial)
 	})
 	return serial, nil
 }
 
+// serialTaken reports whether a certificate of the issuer named rawIssuer
+// already has serial.  CAs sharing a name, such as the generations of a
+// rolled over root, share their serial numbers.  The store must be locked.
+func (s *Store) serialTaken(rawIssuer []byte, serial *big.Int) bool {
+	for _, p := range s.m {
+		if bytes.Equal(p.Certificate.RawIssuer, rawIssuer) && p.SerialNumber().Cmp(serial) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSerial refuses the certificate p, issued by liftCA, if its issuer
+// already issued one with the same serial number.  The store must be
+// locked.
+func (s *Store) checkSerial(p *Parcel) error {
+	if s.serialTaken(p.Certificate.RawIssuer, p.SerialNumber()) {
+		return fmt.Errorf("serial number %v is already used by the issuer", p.SerialNumber())
+	}
+	return nil
+}
+
 func (s *Store) Get(id int64) (*Parcel, bool) {
 	var ret *Parcel = nil
 	var found bool
@@ -193,6 +865,114 @@ func (s *Store) GetChildren(id int64) ([]int64, bool) {
 	return ret, found
 }
 
+// GetPredecessor returns the certificate that id was renewed from.
+func (s *Store) GetPredecessor(id int64) (int64, bool) {
+	var ret int64
+	var found bool
+	s.withRLocked(func() {
+		ret, found = s.predecessor[id]
+	})
+	return ret, found
+}
+
+// GetSuccessors returns the certificates that renewed id.
+func (s *Store) GetSuccessors(id int64) []int64 {
+	var ret []int64
+	s.withRLocked(func() {
+		ret = append(make([]int64, 0), s.successors[id]...)
+	})
+	return ret
+}
+
+// GetCrossCertified returns the CA whose key the cross certificate id
+// certifies.
+func (s *Store) GetCrossCertified(id int64) (int64, bool) {
+	var ret int64
+	var found bool
+	s.withRLocked(func() {
+		ret, found = s.crossOf[id]
+	})
+	return ret, found
+}
+
+// GetCrossCertificates returns the cross certificates that certify the key
+// of the CA id.
+func (s *Store) GetCrossCertificates(id int64) []int64 {
+	ret := make([]int64, 0)
+	s.withRLocked(func() {
+		for cross, ca := range s.crossOf {
+			if ca == id {
+				ret = append(ret, cross)
+			}
+		}
+	})
+	return ret
+}
+
+// GetChain returns the parcel id followed by each of its issuers, up to and
+// including the root CA.
+func (s *Store) GetChain(id int64) ([]*Parcel, bool) {
+	var chain []*Parcel
+	var found bool
+	s.withRLocked(func() {
+		var p *Parcel
+		p, found = s.m[id]
+		for found {
+			chain = append(chain, p)
+			if id, found = s.parent[id]; found {
+				p, found = s.m[id]
+			}
+		}
+		found = len(chain) > 0
+	})
+	return chain, found
+}
+
+// GetChains returns every chain from the parcel id up to a root: the one
+// GetChain returns, followed by those going through cross certificates for
+// the key of one of its issuers.
+func (s *Store) GetChains(id int64) [][]*Parcel {
+	var chains [][]*Parcel
+	s.withRLocked(func() {
+		chains = s.chains(id, make(map[int64]bool))
+	})
+	return chains
+}
+
+// chains returns the chains from id up to a root that do not go through the
+// keys of the CAs in visited.  The store must be locked.
+func (s *Store) chains(id int64, visited map[int64]bool) [][]*Parcel {
+	p, found := s.m[id]
+	if !found {
+		return nil
+	}
+	parent, found := s.parent[id]
+	if !found {
+		return [][]*Parcel{{p}}
+	}
+	if visited[parent] {
+		return nil
+	}
+	visited[parent] = true
+	defer delete(visited, parent)
+
+	issuers := []int64{parent}
+	for cross, ca := range s.crossOf {
+		if ca == parent {
+			issuers = append(issuers, cross)
+		}
+	}
+	sort.Slice(issuers[1:], func(i, j int) bool { return issuers[1+i] < issuers[1+j] })
+
+	chains := make([][]*Parcel, 0)
+	for _, issuer := range issuers {
+		for _, chain := range s.chains(issuer, visited) {
+			chains = append(chains, append([]*Parcel{p}, chain...))
+		}
+	}
+	return chains
+}
+
 func (s *Store) GetRevokedChildren(id int64) []int64 {
 	var revokedChildren []int64
 	s.withRLocked(func() {
@@ -200,8 +980,7 @@ func (s *Store) GetRevokedChildren(id int64) []int64 {
 		children, found := s.children[id]
 		if found {
 			for _, c := range children {
-				revoked, found := s.revoked[c]
-				if found && revoked {
+				if _, revoked := s.revoked[c]; revoked {
 					rrr = append(rrr, c)
 				}
 			}
@@ -211,6 +990,356 @@ func (s *Store) GetRevokedChildren(id int64) []int64 {
 	return revokedChildren
 }
 
+// GetRevokedSerialNumbers returns the certificate serial numbers of the
+// revoked children of the CA id.
+func (s *Store) GetRevokedSerialNumbers(id int64) []*big.Int {
+	serials := make([]*big.Int, 0)
+	for _, c := range s.GetRevokedChildren(id) {
+		if p, found := s.Get(c); found {
+			serials = append(serials, p.SerialNumber())
+		}
+	}
+	return serials
+}
+
+// DERCRL returns the current CRL of the CA id.
+func (s *Store) DERCRL(id int64) (io.Reader, error) {
+	der, err := s.crl(id)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewBuffer(der), nil
+}
+
+// PEMCRL returns the current CRL of the CA id, PEM encoded.
+func (s *Store) PEMCRL(id int64) (io.Reader, error) {
+	der, err := s.crl(id)
+	if err != nil {
+		return nil, err
+	}
+	return pemCRL(der), nil
+}
+
+// DERDeltaCRL returns the current delta CRL of the CA id.
+func (s *Store) DERDeltaCRL(id int64) (io.Reader, error) {
+	der, err := s.deltaCRL(id)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewBuffer(der), nil
+}
+
+// PEMDeltaCRL returns the current delta CRL of the CA id, PEM encoded.
+func (s *Store) PEMDeltaCRL(id int64) (io.Reader, error) {
+	der, err := s.deltaCRL(id)
+	if err != nil {
+		return nil, err
+	}
+	return pemCRL(der), nil
+}
+
+// GetCRLNumber returns the number of the last CRL issued by the CA id, if
+// it issued any.
+func (s *Store) GetCRLNumber(id int64) (*big.Int, bool) {
+	var number *big.Int
+	s.withRLocked(func() {
+		if c, found := s.crls[id]; found {
+			number = new(big.Int).Set(c.Number)
+		}
+	})
+	return number, number != nil
+}
+
+// GetDeltaCRLNumber returns the number of the last delta CRL issued by the
+// CA id, if it issued any.
+func (s *Store) GetDeltaCRLNumber(id int64) (*big.Int, bool) {
+	var number *big.Int
+	s.withRLocked(func() {
+		if c, found := s.deltaCRLs[id]; found {
+			number = new(big.Int).Set(c.Number)
+		}
+	})
+	return number, number != nil
+}
+
+// nextCRLNumber returns the number of the next CRL or delta CRL of the CA
+// id, which share a sequence (RFC 5280, section 5.2.3).  The store must be
+// locked.
+func (s *Store) nextCRLNumber(id int64) *big.Int {
+	last := big.NewInt(0)
+	for _, c := range []*issuedCRL{s.crls[id], s.deltaCRLs[id]} {
+		if c != nil && c.Number.Cmp(last) > 0 {
+			last = c.Number
+		}
+	}
+	return new(big.Int).Add(last, big.NewInt(1))
+}
+
+// crl returns the last CRL issued by the CA id while it is fresh, or else
+// has the CA issue a new one, numbered after the last.
+func (s *Store) crl(id int64) ([]byte, error) {
+	ca, found := s.Get(id)
+	if !found || !ca.Certificate.IsCA {
+		return nil, fmt.Errorf("CA not found")
+	}
+	var extensions []pkix.Extension
+	if dp := s.distributionPoints(id); dp.DeltaCRLURL != "" {
+		ext, err := freshestCRL(dp.DeltaCRLURL)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, ext)
+	}
+	var der []byte
+	s.withRLocked(func() {
+		if c := s.crls[id]; c.fresh(time.Now()) {
+			der = c.DER
+		}
+	})
+	if der != nil {
+		return der, nil
+	}
+
+	var err error
+	s.withLocked(func() {
+		now := time.Now().UTC().Truncate(time.Second)
+		last := s.crls[id]
+		if last.fresh(now) {
+			der = last.DER
+			return
+		}
+		number := s.nextCRLNumber(id)
+		entries := make([]x509.RevocationListEntry, 0)
+		for _, c := range s.children[id] {
+			r, revoked := s.revoked[c]
+			if !revoked {
+				continue
+			}
+			var entry x509.RevocationListEntry
+			if entry, err = r.entry(s.m[c].SerialNumber()); err != nil {
+				return
+			}
+			entries = append(entries, entry)
+		}
+		next := now.Add(ca.CRLTTL())
+		if der, err = ca.derCRLBytes(entries, number, now, next, extensions); err != nil {
+			return
+		}
+		s.crls[id] = &issuedCRL{
+			Number:     number,
+			ThisUpdate: now,
+			NextUpdate: next,
+			Events:     len(s.revocationLog[id]),
+			DER:        der,
+		}
+	})
+	return der, err
+}
+
+// deltaCRL returns the last delta CRL issued by the CA id while it is fresh
+// and relative to its current CRL, or else has the CA issue a new one.
+func (s *Store) deltaCRL(id int64) ([]byte, error) {
+	ca, found := s.Get(id)
+	if !found || !ca.Certificate.IsCA {
+		return nil, fmt.Errorf("CA not found")
+	}
+	if ca.DeltaCRLTTL() == 0 {
+		return nil, fmt.Errorf("CA %v does not publish delta CRLs", id)
+	}
+	// The delta CRL must be relative to a complete CRL that is available.
+	if _, err := s.crl(id); err != nil {
+		return nil, err
+	}
+
+	var der []byte
+	s.withRLocked(func() {
+		if last := s.deltaCRLs[id]; last.fresh(time.Now()) && last.BaseNumber.Cmp(s.crls[id].Number) == 0 {
+			der = last.DER
+		}
+	})
+	if der != nil {
+		return der, nil
+	}
+
+	var err error
+	s.withLocked(func() {
+		now := time.Now().UTC().Truncate(time.Second)
+		base := s.crls[id]
+		last := s.deltaCRLs[id]
+		if last.fresh(now) && last.BaseNumber.Cmp(base.Number) == 0 {
+			der = last.DER
+			return
+		}
+		number := s.nextCRLNumber(id)
+		var entries []x509.RevocationListEntry
+		if entries, err = s.deltaEntries(id, base.Events); err != nil {
+			return
+		}
+		var indicator pkix.Extension
+		if indicator, err = deltaCRLIndicator(base.Number); err != nil {
+			return
+		}
+		next := now.Add(ca.DeltaCRLTTL())
+		if der, err = ca.derCRLBytes(entries, number, now, next, []pkix.Extension{indicator}); err != nil {
+			return
+		}
+		s.deltaCRLs[id] = &issuedCRL{
+			Number:     number,
+			BaseNumber: base.Number,
+			ThisUpdate: now,
+			NextUpdate: next,
+			Events:     len(s.revocationLog[id]),
+			DER:        der,
+		}
+	})
+	return der, err
+}
+
+// deltaEntries returns the delta CRL entries of the certificates of the CA
+// id whose revocation changed after the first n events of its revocation
+// log: those revoked since, or again with another reason, and those
+// unrevoked since, with reason removeFromCRL.  The store must be locked.
+func (s *Store) deltaEntries(id int64, n int) ([]x509.RevocationListEntry, error) {
+	events := s.revocationLog[id]
+	listed := make(map[int64]bool)
+	for _, e := range events[:n] {
+		listed[e.ID] = e.Revocation.Reason != ReasonRemoveFromCRL
+	}
+	changed := make([]int64, 0)
+	latest := make(map[int64]Revocation)
+	for _, e := range events[n:] {
+		if _, found := latest[e.ID]; !found {
+			changed = append(changed, e.ID)
+		}
+		latest[e.ID] = e.Revocation
+	}
+	entries := make([]x509.RevocationListEntry, 0, len(changed))
+	for _, c := range changed {
+		r := latest[c]
+		p, found := s.m[c]
+		if !found || r.Reason == ReasonRemoveFromCRL && !listed[c] {
+			continue
+		}
+		entry, err := r.entry(p.SerialNumber())
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// OCSPResponse answers the DER encoded OCSP request req, sent to the OCSP
+// responder of the CA id (RFC 6960).  Requests that cannot be answered get
+// an OCSP error response; errors are only returned when signing fails.
+func (s *Store) OCSPResponse(id int64, req []byte) ([]byte, error) {
+	r, err := ocsp.ParseRequest(req)
+	if err != nil {
+		return ocsp.MalformedRequestErrorResponse, nil
+	}
+	ca, found := s.Get(id)
+	if !found || !ca.Certificate.IsCA || !ca.HasPrivateKey() || !ca.isOCSPIssuerOf(r) {
+		return ocsp.UnauthorizedErrorResponse, nil
+	}
+	return s.ocspResponse(ca, ocspTemplate(r.SerialNumber, r.HashAlgorithm, ocspResponseTTL))
+}
+
+// OCSPStaple returns an OCSP response about the certificate id, good for
+// OCSPStaplingTTL, for servers to staple to their TLS handshakes.
+func (s *Store) OCSPStaple(id int64) ([]byte, error) {
+	cert, found := s.Get(id)
+	if !found {
+		return nil, fmt.Errorf("certificate not found")
+	}
+	parentId, found := s.GetParent(id)
+	if !found {
+		return nil, fmt.Errorf("issuing CA not found")
+	}
+	ca, found := s.Get(parentId)
+	if !found {
+		return nil, fmt.Errorf("issuing CA not found")
+	}
+	return s.ocspResponse(ca, ocspTemplate(cert.SerialNumber(), crypto.SHA1, OCSPStaplingTTL))
+}
+
+// ocspResponse fills in the status of the certificate of the CA ca that
+// template is about, and signs it.
+func (s *Store) ocspResponse(ca *Parcel, template ocsp.Response) ([]byte, error) {
+	responder, err := s.ocspResponder(ca)
+	if err != nil {
+		return nil, err
+	}
+	s.withRLocked(func() {
+		for _, c := range s.children[ca.ID] {
+			if s.m[c].SerialNumber().Cmp(template.SerialNumber) != 0 {
+				continue
+			}
+			template.Status = ocsp.Good
+			if r, revoked := s.revoked[c]; revoked {
+				template.Status = ocsp.Revoked
+				template.RevokedAt = r.Time
+				template.RevocationReason = int(r.Reason)
+				template.ExtraExtensions, err = r.extensions()
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return signOCSPResponse(ca, responder, template)
+}
+
+// ocspResponder returns the parcel that signs the OCSP responses of the CA
+// ca: the CA itself, or its delegated responder, which is issued anew when
+// it is about to expire.  The responder replaces its predecessor, and is
+// kept out of the certificates of the CA, so that it is not listed,
+// revoked or renewed like those.
+func (s *Store) ocspResponder(ca *Parcel) (*Parcel, error) {
+	if !ca.DelegatedOCSP() {
+		return ca, nil
+	}
+	s.ocspMutex.Lock()
+	defer s.ocspMutex.Unlock()
+
+	var responder *Parcel
+	s.withRLocked(func() {
+		if id, found := s.ocspResponders[ca.ID]; found {
+			responder = s.m[id]
+		}
+	})
+	if responder != nil && !ocspResponderExpiring(ca, responder) {
+		return responder, nil
+	}
+
+	id := s.idsource.Int63()
+	issuers, found := s.GetChain(ca.ID)
+	if !found {
+		return nil, fmt.Errorf("CA not found")
+	}
+	serial, err := s.nextSerial(ca)
+	if err != nil {
+		return nil, err
+	}
+	p, err := makeOCSPResponderParcel(id, serial, issuers, s.distributionPoints(ca.ID))
+	if err != nil {
+		return nil, err
+	}
+	s.withLocked(func() {
+		if err = s.checkSerial(p); err != nil {
+			return
+		}
+		if old, found := s.ocspResponders[ca.ID]; found {
+			delete(s.m, old)
+		}
+		s.m[id] = p
+		s.ocspResponders[ca.ID] = id
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
 func (s *Store) GetCAs() []int64 {
 	var ret []int64 = make([]int64, 0)
 	s.withRLocked(func() {
@@ -221,10 +1350,16 @@ func (s *Store) GetCAs() []int64 {
 	return ret
 }
 
+// withLocked runs f with the store locked for writing, then signals the
+// listeners.  They are signalled once the lock is released, since they read
+// the store in turn; signalling while holding it deadlocks as soon as a
+// second change comes before a listener took its read lock.
 func (s *Store) withLocked(f func()) {
-	s.rw.Lock()
-	defer s.rw.Unlock()
-	f()
+	func() {
+		s.rw.Lock()
+		defer s.rw.Unlock()
+		f()
+	}()
 	s.signalUpdates()
 }
 
@@ -0,0 +1,302 @@
+package liftca
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// addTestCA adds a visible root CA with an ECDSA key, much quicker to
+// generate than the default RSA one.
+func addTestCA(t *testing.T, s *Store, name string, settings CASettings) int64 {
+	t.Helper()
+	id, err := s.AddCA(true, &CARequest{
+		Subject:  pkix.Name{CommonName: name},
+		KeyType:  KeyTypeECDSAP256,
+		Settings: settings,
+	})
+	if err != nil {
+		t.Fatalf("adding CA %v: %v", name, err)
+	}
+	return id
+}
+
+// addTestCert has the CA ca issue a server certificate for dnsName.
+func addTestCert(t *testing.T, s *Store, ca int64, dnsName string) int64 {
+	t.Helper()
+	id, err := s.Add(true, ca, &CertRequest{KeyType: KeyTypeECDSAP256, DNSNames: []string{dnsName}})
+	if err != nil {
+		t.Fatalf("issuing certificate for %v: %v", dnsName, err)
+	}
+	return id
+}
+
+func getTestParcel(t *testing.T, s *Store, id int64) *Parcel {
+	t.Helper()
+	p, found := s.Get(id)
+	if !found {
+		t.Fatalf("parcel %v not found", id)
+	}
+	return p
+}
+
+func readTestPEM(t *testing.T, r io.Reader) []byte {
+	t.Helper()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// testDeltaDistribution makes every CA of s point to its CRL and delta CRL.
+func testDeltaDistribution(s *Store) {
+	s.SetDistribution(func(ca int64) DistributionPoints {
+		return DistributionPoints{
+			CRLURL:      fmt.Sprintf("http://ca.lab/%v.crl", ca),
+			DeltaCRLURL: fmt.Sprintf("http://ca.lab/%v-delta.crl", ca),
+		}
+	})
+}
+
+// freshestCRLCount returns how many FreshestCRL extensions cert has.
+func freshestCRLCount(cert *x509.Certificate) int {
+	n := 0
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidExtensionFreshestCRL) {
+			n++
+		}
+	}
+	return n
+}
+
+func verifyTestChain(t *testing.T, s *Store, id int64) {
+	t.Helper()
+	chain, found := s.GetChain(id)
+	if !found {
+		t.Fatalf("no chain for %v", id)
+	}
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	roots.AddCert(chain[len(chain)-1].Certificate)
+	for _, p := range chain[1 : len(chain)-1] {
+		intermediates.AddCert(p.Certificate)
+	}
+	_, err := chain[0].Certificate.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		t.Fatalf("verifying %v: %v", id, err)
+	}
+}
+
+func TestKeyTypes(t *testing.T) {
+	for _, kt := range KeyTypes() {
+		t.Run(string(kt), func(t *testing.T) {
+			s := NewStore()
+			ca, err := s.AddCA(true, &CARequest{Subject: pkix.Name{CommonName: "root"}, KeyType: kt})
+			if err != nil {
+				t.Fatal(err)
+			}
+			id, err := s.Add(true, ca, &CertRequest{KeyType: kt, DNSNames: []string{"www.lab"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range []*Parcel{getTestParcel(t, s, ca), getTestParcel(t, s, id)} {
+				if p.KeyType() != kt {
+					t.Errorf("key type of %v is %v, want %v", p.Certificate.Subject, p.KeyType(), kt)
+				}
+			}
+			verifyTestChain(t, s, id)
+		})
+	}
+}
+
+func TestSequentialSerials(t *testing.T) {
+	s := NewStore()
+	ca := addTestCA(t, s, "root", CASettings{SerialPolicy: SerialSequential})
+	for want := int64(1); want <= 3; want++ {
+		p := getTestParcel(t, s, addTestCert(t, s, ca, "www.lab"))
+		if p.SerialNumber().Cmp(big.NewInt(want)) != 0 {
+			t.Errorf("serial number is %v, want %v", p.SerialNumber(), want)
+		}
+	}
+	// The counter is kept per CA.
+	other := addTestCA(t, s, "other", CASettings{SerialPolicy: SerialSequential})
+	if p := getTestParcel(t, s, addTestCert(t, s, other, "www.lab")); p.SerialNumber().Cmp(big.NewInt(1)) != 0 {
+		t.Errorf("serial number under another CA is %v, want 1", p.SerialNumber())
+	}
+}
+
+func TestRandomSerials(t *testing.T) {
+	s := NewStore()
+	ca := addTestCA(t, s, "root", CASettings{SerialPolicy: SerialRandom})
+	seen := make(map[string]bool)
+	for i := 0; i < 5; i++ {
+		serial := getTestParcel(t, s, addTestCert(t, s, ca, "www.lab")).SerialNumber()
+		if serial.Sign() <= 0 || serial.Cmp(maxRandomSerial) >= 0 {
+			t.Errorf("serial number %v out of range", serial)
+		}
+		if seen[serial.String()] {
+			t.Errorf("serial number %v used twice", serial)
+		}
+		seen[serial.String()] = true
+	}
+}
+
+func TestAddExistingCATwice(t *testing.T) {
+	src := NewStore()
+	ca := getTestParcel(t, src, addTestCA(t, src, "root", CASettings{}))
+	cert, key := readTestPEM(t, ca.PEMCertificate()), readTestPEM(t, ca.PEMPrivateKey())
+
+	s := NewStore()
+	id, err := s.AddExistingCA(true, cert, key, nil, nil, CASettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.AddExistingCA(true, cert, key, nil, nil, CASettings{})
+	var existing *ExistingCAError
+	if !errors.As(err, &existing) {
+		t.Fatalf("importing the CA again: got %v, want an ExistingCAError", err)
+	}
+	if existing.ID != id {
+		t.Errorf("ExistingCAError.ID is %v, want %v", existing.ID, id)
+	}
+}
+
+func TestAddExistingCertsUnderInvisibleCA(t *testing.T) {
+	src := NewStore()
+	srcCA := addTestCA(t, src, "root", CASettings{})
+	ca := getTestParcel(t, src, srcCA)
+	leaf := readTestPEM(t, getTestParcel(t, src, addTestCert(t, src, srcCA, "www.lab")).PEMCertificate())
+
+	s := NewStore()
+	hidden, err := s.AddExistingCA(false, readTestPEM(t, ca.PEMCertificate()), readTestPEM(t, ca.PEMPrivateKey()), nil, nil, CASettings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported, err := s.AddExistingCerts(true, leaf, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported[0].Err == nil || imported[0].ParentID != 0 {
+		t.Fatalf("certificate of an invisible CA imported without its id: parent %v", imported[0].ParentID)
+	}
+
+	imported, err = s.AddExistingCerts(true, leaf, nil, nil, []int64{hidden})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported[0].Err != nil {
+		t.Fatal(imported[0].Err)
+	}
+	if imported[0].ParentID != hidden {
+		t.Errorf("certificate filed under %v, want %v", imported[0].ParentID, hidden)
+	}
+	if parent, _ := s.GetParent(imported[0].ID); parent != hidden {
+		t.Errorf("parent of the imported certificate is %v, want %v", parent, hidden)
+	}
+}
+
+// Renewals copy the extensions of the renewed certificate; the FreshestCRL
+// extension must not be copied along with the one liftCA adds.
+func TestRenewUnderDeltaCRLCA(t *testing.T) {
+	s := NewStore()
+	testDeltaDistribution(s)
+	ca := addTestCA(t, s, "root", CASettings{DeltaCRLTTL: time.Hour})
+	id := addTestCert(t, s, ca, "www.lab")
+	if n := freshestCRLCount(getTestParcel(t, s, id).Certificate); n != 1 {
+		t.Fatalf("issued certificate has %v FreshestCRL extensions, want 1", n)
+	}
+	for _, req := range []RenewRequest{{}, {Rekey: true}} {
+		renewed, err := s.Renew(id, &req)
+		if err != nil {
+			t.Fatalf("renewing with %+v: %v", req, err)
+		}
+		if n := freshestCRLCount(getTestParcel(t, s, renewed).Certificate); n != 1 {
+			t.Errorf("renewed certificate has %v FreshestCRL extensions, want 1", n)
+		}
+	}
+}
+
+func TestCrossSignUnderDeltaCRLCA(t *testing.T) {
+	s := NewStore()
+	testDeltaDistribution(s)
+	root := addTestCA(t, s, "root", CASettings{DeltaCRLTTL: time.Hour})
+	sub, err := s.AddSubCA(true, root, &CARequest{Subject: pkix.Name{CommonName: "sub"}, KeyType: KeyTypeECDSAP256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := addTestCA(t, s, "other", CASettings{DeltaCRLTTL: time.Hour})
+	cross, err := s.CrossSign(other, sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := getTestParcel(t, s, cross)
+	if n := freshestCRLCount(p.Certificate); n != 1 {
+		t.Errorf("cross certificate has %v FreshestCRL extensions, want 1", n)
+	}
+	if !bytes.Equal(p.Certificate.RawSubjectPublicKeyInfo, getTestParcel(t, s, sub).Certificate.RawSubjectPublicKeyInfo) {
+		t.Error("cross certificate does not certify the key of the sub-CA")
+	}
+	verifyTestChain(t, s, cross)
+}
+
+func TestWithLockedReleasesLockOnPanic(t *testing.T) {
+	s := NewStore()
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("panic not propagated")
+			}
+		}()
+		s.withLocked(func() { panic("change failed") })
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		s.withLocked(func() {})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("store still locked after a panic")
+	}
+}
+
+func TestDumpAndLoadStore(t *testing.T) {
+	s := NewStore()
+	ca := addTestCA(t, s, "root", CASettings{DeltaCRLTTL: time.Hour})
+	id := addTestCert(t, s, ca, "www.lab")
+	if err := s.Revoke(id, Revocation{Reason: ReasonKeyCompromise}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.DERCRL(ca); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	s.DumpStore(&buf)
+	loaded := LoadStore(&buf)
+	if r, found := loaded.GetRevocation(id); !found || r.Reason != ReasonKeyCompromise {
+		t.Errorf("revocation after loading is %+v, %v", r, found)
+	}
+	number, _ := s.GetCRLNumber(ca)
+	if n, found := loaded.GetCRLNumber(ca); !found || n.Cmp(number) != 0 {
+		t.Errorf("CRL number after loading is %v, want %v", n, number)
+	}
+	block, _ := pem.Decode(readTestPEM(t, getTestParcel(t, loaded, ca).PEMPrivateKey()))
+	if block == nil {
+		t.Error("private key of the CA lost")
+	}
+}
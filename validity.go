@@ -0,0 +1,80 @@
+package liftca
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultCATTL is the validity period of CAs created without one.
+	DefaultCATTL = 10 * 365 * 24 * time.Hour
+	// DefaultCertTTL is the validity period of certificates issued by CAs
+	// without a default of their own.  It stays under the 398 day limit
+	// enforced by Apple platforms and Chrome.
+	DefaultCertTTL = 397 * 24 * time.Hour
+	// DefaultCRLTTL is how long the CRLs of CAs without a CRL validity of
+	// their own are good for.
+	DefaultCRLTTL = 24 * time.Hour
+	// backdate is taken off the current time when no start is requested, to
+	// tolerate clocks that are slightly behind ours.
+	backdate = 5 * time.Minute
+)
+
+// Validity is a requested validity period.  Zero fields are filled in from
+// defaults; at most one of NotAfter and TTL may be set.
+type Validity struct {
+	NotBefore time.Time
+	NotAfter  time.Time
+	TTL       time.Duration
+}
+
+// period computes the validity period, ending defaultTTL after its start if
+// v does not say otherwise.  When issuer is not nil the period must fit
+// within the issuer's own: explicit requests that do not fit are refused,
+// and defaults are shortened to fit.
+func (v *Validity) period(defaultTTL time.Duration, issuer *x509.Certificate) (notBefore, notAfter time.Time, err error) {
+	if !v.NotAfter.IsZero() && v.TTL != 0 {
+		err = fmt.Errorf("only one of notAfter and ttl may be given")
+		return
+	}
+	if v.TTL < 0 {
+		err = fmt.Errorf("ttl must be positive")
+		return
+	}
+
+	notBefore = v.NotBefore.UTC()
+	if v.NotBefore.IsZero() {
+		notBefore = time.Now().Add(-backdate).UTC().Truncate(time.Second)
+	}
+	switch {
+	case !v.NotAfter.IsZero():
+		notAfter = v.NotAfter.UTC()
+	case v.TTL != 0:
+		notAfter = notBefore.Add(v.TTL)
+	default:
+		notAfter = notBefore.Add(defaultTTL)
+	}
+
+	if issuer != nil {
+		if notBefore.Before(issuer.NotBefore) {
+			if !v.NotBefore.IsZero() {
+				err = fmt.Errorf("certificate cannot be valid before its issuer (valid from %v)", issuer.NotBefore)
+				return
+			}
+			notBefore = issuer.NotBefore
+		}
+		if notAfter.After(issuer.NotAfter) {
+			if !v.NotAfter.IsZero() || v.TTL != 0 {
+				err = fmt.Errorf("certificate cannot outlive its issuer (valid until %v)", issuer.NotAfter)
+				return
+			}
+			notAfter = issuer.NotAfter
+		}
+	}
+
+	if !notAfter.After(notBefore) {
+		err = fmt.Errorf("notAfter (%v) must be later than notBefore (%v)", notAfter, notBefore)
+	}
+	return
+}